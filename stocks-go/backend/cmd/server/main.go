@@ -3,30 +3,228 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 	"stocks-backend/internal/api"
 	"stocks-backend/internal/auth"
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/market"
 	"stocks-backend/internal/simulation"
 	"stocks-backend/internal/storage"
 	"stocks-backend/internal/websocket"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	// Initialize storage
-	store := storage.GetInstance()
+	// Initialize storage: in memory by default, or journaled to DATA_DIR
+	// with STORAGE=file so that state survives restarts
+	var store storage.Store
+	switch os.Getenv("STORAGE") {
+	case "", "memory":
+		store = storage.GetInstance()
+	case "file":
+		dir := os.Getenv("DATA_DIR")
+		if dir == "" {
+			dir = "data"
+		}
+		fileStore, err := storage.OpenFileStore(dir)
+		if err != nil {
+			log.Fatalf("Error opening storage in %s: %v", dir, err)
+		}
+		defer fileStore.Close()
+		store = fileStore
+	default:
+		log.Fatalf("Unknown STORAGE %q: use memory or file", os.Getenv("STORAGE"))
+	}
+	store.SetMarginPolicy(storage.MarginPolicy{
+		InitialMargin:     envDecimal("MARGIN_INITIAL", storage.DefaultMarginPolicy.InitialMargin),
+		MaintenanceMargin: envDecimal("MARGIN_MAINTENANCE", storage.DefaultMarginPolicy.MaintenanceMargin),
+	})
+	store.SetFeeRate(envDecimal("TRADE_FEE_RATE", decimal.Zero))
+	breakers := storage.DefaultCircuitBreakerPolicy
+	breakers.Band = envDecimal("HALT_BAND", breakers.Band)
+	breakers.Window = envDuration("HALT_WINDOW", breakers.Window)
+	breakers.Cooldown = envDuration("HALT_COOLDOWN", breakers.Cooldown)
+	switch value := os.Getenv("HALT_ORDERS"); value {
+	case "", "reject":
+	case "queue":
+		breakers.Queue = true
+	default:
+		log.Printf("Ignoring invalid HALT_ORDERS=%q: use reject or queue", value)
+	}
+	store.SetCircuitBreakerPolicy(breakers)
+	if method := os.Getenv("COST_BASIS_METHOD"); method != "" {
+		if storage.IsCostBasisMethod(method) {
+			store.SetCostBasisMethod(method)
+		} else {
+			log.Printf("Ignoring invalid COST_BASIS_METHOD=%q: use fifo, lifo or average", method)
+		}
+	}
+
+	// List the initial universe: the default stocks, or the instruments in
+	// the JSON file INSTRUMENTS_FILE. A restored FileStore keeps its own.
+	if len(store.Instruments()) == 0 {
+		instruments := storage.DefaultInstruments
+		if path := os.Getenv("INSTRUMENTS_FILE"); path != "" {
+			loaded, err := storage.LoadInstruments(path)
+			if err != nil {
+				log.Fatalf("Error loading instruments: %v", err)
+			}
+			instruments = loaded
+		}
+		for _, instrument := range instruments {
+			if len(instrument.Model) > 0 {
+				if _, err := simulation.ParseModel(instrument.Model); err != nil {
+					log.Fatalf("Invalid model for %s: %v", instrument.Symbol, err)
+				}
+			}
+			if _, err := store.ListInstrument(instrument); err != nil {
+				log.Fatalf("Error listing %s: %v", instrument.Symbol, err)
+			}
+		}
+	}
 
 	// Initialize WebSocket hub
 	hub := websocket.NewHub()
+	wsOptions := websocket.DefaultOptions
+	if value := os.Getenv("WS_SLOW_CONSUMER"); value != "" {
+		if policy, err := websocket.ParseSlowConsumerPolicy(value); err == nil {
+			wsOptions.SlowConsumer = policy
+		} else {
+			log.Printf("Ignoring WS_SLOW_CONSUMER: %v", err)
+		}
+	}
+	if value := os.Getenv("WS_SEND_BUFFER"); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size > 0 {
+			wsOptions.SendBuffer = size
+		} else {
+			log.Printf("Ignoring invalid WS_SEND_BUFFER=%q: use a number of messages", value)
+		}
+	}
+	wsOptions.PingInterval = envDuration("WS_PING_INTERVAL", wsOptions.PingInterval)
+	wsOptions.PongWait = envDuration("WS_PONG_WAIT", wsOptions.PongWait)
+	wsOptions.WriteWait = envDuration("WS_WRITE_WAIT", wsOptions.WriteWait)
+	hub.SetOptions(wsOptions)
 	go hub.Run()
 
-	// Initialize price simulator
-	simulator := simulation.NewSimulator(store, hub)
-	simulator.Start()
-	defer simulator.Stop()
+	// Exchange calendar: open around the clock by default, US equity
+	// sessions and holidays with MARKET_CALENDAR=us, or a JSON config file
+	var calendar *market.Calendar
+	switch value := os.Getenv("MARKET_CALENDAR"); value {
+	case "", "always":
+		calendar = market.AlwaysOpen()
+	case "us":
+		calendar = market.USEquities()
+	default:
+		loaded, err := market.LoadCalendar(value)
+		if err != nil {
+			log.Fatalf("Error loading market calendar: %v", err)
+		}
+		calendar = loaded
+	}
+	monitor := market.NewMonitor(calendar, hub, time.Second)
+	monitor.Start()
+	defer monitor.Stop()
+
+	// Initialize price simulator. The seed is logged so a run can be
+	// reproduced with SIM_SEED.
+	simConfig := simulation.DefaultConfig
+	simConfig.Seed = time.Now().UnixNano()
+	simConfig.Calendar = calendar
+	if value := os.Getenv("SIM_SEED"); value != "" {
+		seed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid SIM_SEED=%q: %v", value, err)
+		}
+		simConfig.Seed = seed
+	}
+	if value := os.Getenv("SIM_TIME_STEP"); value != "" {
+		step, err := time.ParseDuration(value)
+		if err != nil || step <= 0 {
+			log.Fatalf("Invalid SIM_TIME_STEP=%q: use a duration such as 5m", value)
+		}
+		simConfig.TimeStep = step
+	}
+	if path := os.Getenv("SIM_MODELS"); path != "" {
+		models, err := simulation.LoadModels(path)
+		if err != nil {
+			log.Fatalf("Error loading price models: %v", err)
+		}
+		simConfig.Models = models
+	}
+	if path := os.Getenv("SIM_CORRELATION"); path != "" {
+		matrix, err := simulation.LoadCorrelation(path)
+		if err != nil {
+			log.Fatalf("Error loading correlation matrix: %v", err)
+		}
+		simConfig.Correlation = matrix
+	}
+
+	// Prices are simulated unless SIM_SOURCE=replay plays back the CSV feed
+	// in REPLAY_FILE. RECORD_FILE records simulated prices in that format.
+	var replay *simulation.Replay
+	switch os.Getenv("SIM_SOURCE") {
+	case "", "simulate":
+		simulator := simulation.NewSimulator(store, hub, simConfig)
+		if path := os.Getenv("RECORD_FILE"); path != "" {
+			recorder, err := simulation.NewRecorder(path, store)
+			if err != nil {
+				log.Fatalf("Error opening %s for recording: %v", path, err)
+			}
+			defer recorder.Close()
+			simulator.Record(recorder)
+			log.Printf("Recording prices to %s", path)
+		}
+		simulator.Start()
+		defer simulator.Stop()
+	case "replay":
+		bars, err := simulation.LoadBars(os.Getenv("REPLAY_FILE"))
+		if err != nil {
+			log.Fatalf("Error loading REPLAY_FILE: %v", err)
+		}
+		options := simulation.ReplayOptions{Speed: 1, Loop: os.Getenv("REPLAY_LOOP") == "true"}
+		if value := os.Getenv("REPLAY_SPEED"); value != "" {
+			if options.Speed, err = simulation.ParseSpeed(value); err != nil {
+				log.Fatalf("Invalid REPLAY_SPEED: %v", err)
+			}
+		}
+		replay, err = simulation.NewReplay(store, hub, bars, options)
+		if err != nil {
+			log.Fatalf("Error starting replay: %v", err)
+		}
+		replay.Start()
+		defer replay.Stop()
+	default:
+		log.Fatalf("Unknown SIM_SOURCE %q: use simulate or replay", os.Getenv("SIM_SOURCE"))
+	}
+
+	// Expire DAY and GTD orders in the background
+	sweeper := storage.NewExpirySweeper(store, time.Second)
+	sweeper.Start()
+	defer sweeper.Stop()
+
+	// Apply stock splits and dividends as they fall due
+	actions := market.NewActionScheduler(store, hub, time.Second)
+	actions.Start()
+	defer actions.Stop()
+
+	// Push order and account changes to their users' WebSocket clients
+	userFeed := api.NewUserFeed(store, hub, 250*time.Millisecond)
+	userFeed.Start()
+	defer userFeed.Stop()
 
 	// Initialize handlers
 	handlers := api.NewHandlers(store, hub)
+	handlers.SetCalendar(calendar)
+	if value := os.Getenv("ADMIN_USERS"); value != "" {
+		handlers.SetAdmins(strings.Split(value, ","))
+	}
+	if replay != nil {
+		handlers.SetReplay(replay)
+	}
 
 	// Create router
 	router := mux.NewRouter()
@@ -39,6 +237,13 @@ func main() {
 	router.HandleFunc("/login", handlers.Login).Methods("POST", "OPTIONS")
 	router.HandleFunc("/prices", handlers.GetPrices).Methods("GET", "OPTIONS")
 	router.HandleFunc("/stocks/{symbol}", handlers.GetStockDetail).Methods("GET", "OPTIONS")
+	router.HandleFunc("/stocks/{symbol}/book", handlers.GetOrderBook).Methods("GET", "OPTIONS")
+	router.HandleFunc("/stocks/{symbol}/candles", handlers.GetCandles).Methods("GET", "OPTIONS")
+	router.HandleFunc("/stocks/{symbol}/history", handlers.GetPriceHistory).Methods("GET", "OPTIONS")
+	router.HandleFunc("/market/status", handlers.GetMarketStatus).Methods("GET", "OPTIONS")
+	router.HandleFunc("/halts", handlers.GetHalts).Methods("GET", "OPTIONS")
+	router.HandleFunc("/instruments", handlers.GetInstruments).Methods("GET", "OPTIONS")
+	router.HandleFunc("/corporate-actions", handlers.GetCorporateActions).Methods("GET", "OPTIONS")
 	router.HandleFunc("/ws", handlers.HandleWebSocket)
 
 	// Protected routes
@@ -47,7 +252,25 @@ func main() {
 	protectedRouter.Use(auth.JWTMiddleware)
 	protectedRouter.HandleFunc("/orders", handlers.CreateOrder).Methods("POST", "OPTIONS")
 	protectedRouter.HandleFunc("/orders", handlers.GetOrders).Methods("GET", "OPTIONS")
+	protectedRouter.HandleFunc("/orders/bracket", handlers.CreateBracketOrder).Methods("POST", "OPTIONS")
+	protectedRouter.HandleFunc("/orders/oco", handlers.CreateOCOOrder).Methods("POST", "OPTIONS")
+	protectedRouter.HandleFunc("/orders/{id}", handlers.CancelOrder).Methods("DELETE", "OPTIONS")
+	protectedRouter.HandleFunc("/orders/{id}", handlers.AmendOrder).Methods("PATCH", "OPTIONS")
+	protectedRouter.HandleFunc("/trades", handlers.GetTrades).Methods("GET", "OPTIONS")
 	protectedRouter.HandleFunc("/account", handlers.GetAccount).Methods("GET", "OPTIONS")
+	protectedRouter.HandleFunc("/ledger", handlers.GetLedger).Methods("GET", "OPTIONS")
+	protectedRouter.HandleFunc("/ledger/check", handlers.CheckLedger).Methods("GET", "OPTIONS")
+	protectedRouter.HandleFunc("/replay", handlers.GetReplay).Methods("GET", "OPTIONS")
+
+	// Admin routes, for the users in ADMIN_USERS
+	protectedRouter.HandleFunc("/admin/instruments", handlers.Admin(handlers.GetInstruments)).Methods("GET", "OPTIONS")
+	protectedRouter.HandleFunc("/admin/instruments", handlers.Admin(handlers.ListInstrument)).Methods("POST", "OPTIONS")
+	protectedRouter.HandleFunc("/admin/instruments/{symbol}", handlers.Admin(handlers.DelistInstrument)).Methods("DELETE", "OPTIONS")
+	protectedRouter.HandleFunc("/admin/corporate-actions", handlers.Admin(handlers.GetCorporateActions)).Methods("GET", "OPTIONS")
+	protectedRouter.HandleFunc("/admin/corporate-actions", handlers.Admin(handlers.ScheduleCorporateAction)).Methods("POST", "OPTIONS")
+	protectedRouter.HandleFunc("/admin/corporate-actions/{id}", handlers.Admin(handlers.CancelCorporateAction)).Methods("DELETE", "OPTIONS")
+	protectedRouter.HandleFunc("/admin/websocket", handlers.Admin(handlers.GetWebSocketStats)).Methods("GET", "OPTIONS")
+	protectedRouter.HandleFunc("/admin/replay", handlers.Admin(handlers.ControlReplay)).Methods("PATCH", "OPTIONS")
 
 	// Start server
 	log.Println("Server starting on :8080")
@@ -56,6 +279,34 @@ func main() {
 	}
 }
 
+// envDecimal reads a decimal setting from the environment, falling back to def
+func envDecimal(name string, def decimal.Decimal) decimal.Decimal {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := decimal.Parse(value)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", name, value, err)
+		return def
+	}
+	return parsed
+}
+
+// envDuration reads a duration setting from the environment, falling back to def
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		log.Printf("Ignoring invalid %s=%q: use a duration such as 5m", name, value)
+		return def
+	}
+	return parsed
+}
+
 // corsMiddleware adds CORS headers - fully permissive for development
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"stocks-backend/internal/storage"
+	"stocks-backend/internal/websocket"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// GetCorporateActions returns the scheduled, applied and cancelled
+// corporate actions, optionally only those on the symbol query parameter
+func (h *Handlers) GetCorporateActions(w http.ResponseWriter, r *http.Request) {
+	symbol := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("symbol")))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.storage.CorporateActions(symbol))
+}
+
+// ScheduleCorporateAction schedules a stock split or cash dividend (admin)
+func (h *Handlers) ScheduleCorporateAction(w http.ResponseWriter, r *http.Request) {
+	var req storage.CorporateAction
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	action, err := h.storage.ScheduleCorporateAction(req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == storage.ErrStockNotFound {
+			status = http.StatusNotFound
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	h.broadcastCorporateAction(action)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(action)
+}
+
+// CancelCorporateAction cancels a corporate action that has not taken
+// effect yet (admin)
+func (h *Handlers) CancelCorporateAction(w http.ResponseWriter, r *http.Request) {
+	action, err := h.storage.CancelCorporateAction(mux.Vars(r)["id"])
+	if err != nil {
+		status := http.StatusConflict
+		if err == storage.ErrActionNotFound {
+			status = http.StatusNotFound
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	h.broadcastCorporateAction(action)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(action)
+}
+
+// broadcastCorporateAction notifies WebSocket clients that a corporate
+// action was scheduled or cancelled
+func (h *Handlers) broadcastCorporateAction(action storage.CorporateAction) {
+	if err := h.hub.Publish(websocket.ChannelCorporateActions, action.Symbol, map[string]interface{}{
+		"type":   "corporateAction",
+		"action": action,
+	}); err != nil {
+		log.Printf("Error broadcasting corporate action: %v", err)
+	}
+}
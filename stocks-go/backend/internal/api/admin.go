@@ -0,0 +1,33 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// SetAdmins names the users allowed to use the admin endpoints. Without
+// any, the admin endpoints are closed to everyone.
+func (h *Handlers) SetAdmins(usernames []string) {
+	h.admins = make(map[string]bool, len(usernames))
+	for _, username := range usernames {
+		h.admins[username] = true
+	}
+}
+
+// Admin restricts a protected handler to admins
+func (h *Handlers) Admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, ok := r.Context().Value("username").(string)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !h.admins[username] {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Admin access required"})
+			return
+		}
+		next(w, r)
+	}
+}
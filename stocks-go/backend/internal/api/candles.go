@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"stocks-backend/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// GetCandles returns OHLCV bars for a symbol. The query takes an interval
+// (1m, 5m, 1h or 1d; default 1m) and an optional from/to range, each an
+// RFC 3339 timestamp or Unix seconds.
+func (h *Handlers) GetCandles(w http.ResponseWriter, r *http.Request) {
+	symbol := strings.ToUpper(mux.Vars(r)["symbol"])
+	query := r.URL.Query()
+
+	interval := query.Get("interval")
+	if interval == "" {
+		interval = "1m"
+	}
+	from, fromErr := parseTime(query.Get("from"))
+	to, toErr := parseTime(query.Get("to"))
+	if fromErr != nil || toErr != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "from and to must be RFC 3339 timestamps or Unix seconds"})
+		return
+	}
+
+	candles, err := h.storage.Candles(symbol, interval, from, to)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == storage.ErrStockNotFound {
+			status = http.StatusNotFound
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(candles)
+}
+
+// parseTime reads an RFC 3339 timestamp or Unix seconds; empty is the zero time
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -3,16 +3,17 @@ package api
 import (
 	"encoding/json"
 	"log"
-	"math"
 	"net/http"
 	"stocks-backend/internal/auth"
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/market"
+	"stocks-backend/internal/simulation"
 	"stocks-backend/internal/storage"
 	"stocks-backend/internal/websocket"
 	"time"
 
 	"strings"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	ws "github.com/gorilla/websocket"
 )
@@ -29,12 +30,16 @@ var upgrader = ws.Upgrader{
 
 // Handlers contains all HTTP handlers
 type Handlers struct {
-	storage *storage.Storage
+	storage storage.Store
 	hub     *websocket.Hub
+	replay  *simulation.Replay // set when prices come from a historical feed
+
+	calendar *market.Calendar // sessions that order entry follows, if set
+	admins   map[string]bool  // users allowed to use the admin endpoints
 }
 
 // NewHandlers creates a new Handlers instance
-func NewHandlers(store *storage.Storage, hub *websocket.Hub) *Handlers {
+func NewHandlers(store storage.Store, hub *websocket.Hub) *Handlers {
 	return &Handlers{
 		storage: store,
 		hub:     hub,
@@ -49,24 +54,40 @@ type LoginRequest struct {
 
 // SignupRequest represents the signup request body
 type SignupRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	AccountType string `json:"accountType"` // "cash" (default) or "margin"
 }
 
 // LoginResponse represents the login response
 type LoginResponse struct {
-	Token   string  `json:"token"`
-	User    string  `json:"user"`
-	Credits float64 `json:"credits"`
+	Token   string          `json:"token"`
+	User    string          `json:"user"`
+	Credits decimal.Decimal `json:"credits"`
 }
 
 // OrderRequest represents the order creation request
 type OrderRequest struct {
-	Symbol    string  `json:"symbol"`
-	Side      string  `json:"side"`
-	OrderType string  `json:"orderType"` // "market" or "limit"
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
+	Symbol    string          `json:"symbol"`
+	Side      string          `json:"side"`
+	OrderType string          `json:"orderType"` // "market", "limit", "stop", "stop_limit" or "trailing_stop"
+	Quantity  int             `json:"quantity"`
+	Price     decimal.Decimal `json:"price"`     // limit price for "limit" and "stop_limit"
+	StopPrice decimal.Decimal `json:"stopPrice"` // trigger price for "stop" and "stop_limit"
+
+	// Trailing stops take exactly one of an absolute or percentage trail
+	TrailAmount  decimal.Decimal `json:"trailAmount"`
+	TrailPercent float64         `json:"trailPercent"`
+
+	TimeInForce string     `json:"timeInForce"` // "GTC" (default), "DAY", "GTD", "IOC" or "FOK"
+	ExpiresAt   *time.Time `json:"expiresAt"`   // required for "GTD"
+}
+
+// AmendOrderRequest represents the order amendment request. Omitted (zero)
+// fields keep their current value; quantity is the new total quantity.
+type AmendOrderRequest struct {
+	Price    decimal.Decimal `json:"price"`
+	Quantity int             `json:"quantity"`
 }
 
 // Signup handles user registration
@@ -88,10 +109,21 @@ func (h *Handlers) Signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Signup: Attempting to create account for %s", req.Username)
+	req.AccountType = strings.ToLower(strings.TrimSpace(req.AccountType))
+	if req.AccountType == "" {
+		req.AccountType = "cash"
+	}
+	if req.AccountType != "cash" && req.AccountType != "margin" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "AccountType must be 'cash' or 'margin'"})
+		return
+	}
+
+	log.Printf("Signup: Attempting to create %s account for %s", req.AccountType, req.Username)
 
 	// Create account with password
-	account := h.storage.CreateAccount(req.Username, req.Password)
+	account := h.storage.CreateAccount(req.Username, req.Password, req.AccountType)
 	if account == nil {
 		log.Printf("Signup: Account already exists for %s", req.Username)
 		w.Header().Set("Content-Type", "application/json")
@@ -161,7 +193,7 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 	// Get account (we know it exists because ValidatePassword returned true)
 	account := h.storage.GetAccount(req.Username)
 
-	log.Printf("Login: Found account for %s with %.2f credits", req.Username, account.Credits)
+	log.Printf("Login: Found account for %s with %s credits", req.Username, account.Credits)
 
 	// Generate JWT token
 	token, err := auth.GenerateToken(req.Username)
@@ -215,6 +247,25 @@ func (h *Handlers) GetStockDetail(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(price)
 }
 
+// GetOrderBook returns the aggregated depth of a symbol's order book
+func (h *Handlers) GetOrderBook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	symbol := strings.ToUpper(vars["symbol"])
+
+	bids, asks, exists := h.storage.OrderBookDepth(symbol, 10)
+	if !exists {
+		http.Error(w, "Stock not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"symbol": symbol,
+		"bids":   bids,
+		"asks":   asks,
+	})
+}
+
 // CreateOrder handles order creation (protected)
 func (h *Handlers) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	// Get username from context (set by auth middleware)
@@ -243,112 +294,152 @@ func (h *Handlers) CreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Normalize inputs (be lenient on casing/whitespace)
-	req.Symbol = strings.ToUpper(strings.TrimSpace(req.Symbol))
-	req.Side = strings.ToLower(strings.TrimSpace(req.Side))
-	req.OrderType = strings.ToLower(strings.TrimSpace(req.OrderType))
+	log.Printf("CreateOrder: User=%s, Request=%+v", username, req)
 
-	log.Printf("CreateOrder: User=%s, Request(normalized)=%+v", username, req)
+	order, err := h.newOrder(username, req)
+	if err != nil {
+		writeOrderRequestError(w, err)
+		return
+	}
 
-	// Validate input
-	if req.Symbol == "" {
-		log.Println("CreateOrder: Symbol is required")
+	// Match the order against the book; the status reflects what executed
+	if err := h.storage.SubmitOrder(&order); err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Symbol is required"})
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
-	if req.Side != "buy" && req.Side != "sell" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Side must be 'buy' or 'sell'"})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(order)
+}
+
+// GetOrders returns all orders for the authenticated user (protected)
+func (h *Handlers) GetOrders(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, ok := r.Context().Value("username").(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	if req.OrderType != "market" && req.OrderType != "limit" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "OrderType must be 'market' or 'limit'"})
+
+	orders := h.storage.GetOrders(username)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orders)
+}
+
+// GetTrades returns the authenticated user's executions (protected).
+// Optional symbol and orderId query parameters narrow the result.
+func (h *Handlers) GetTrades(w http.ResponseWriter, r *http.Request) {
+	username, ok := r.Context().Value("username").(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	if req.Quantity <= 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Quantity must be greater than 0"})
+
+	symbol := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("symbol")))
+	orderID := strings.TrimSpace(r.URL.Query().Get("orderId"))
+
+	executions := h.storage.GetExecutions(username, symbol, orderID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(executions)
+}
+
+// CancelOrder withdraws one of the user's open orders (protected)
+func (h *Handlers) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	username, ok := r.Context().Value("username").(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	if req.OrderType == "limit" && req.Price <= 0 {
+
+	orderID := mux.Vars(r)["id"]
+	order, err := h.storage.CancelOrder(username, orderID)
+	if err != nil {
+		log.Printf("CancelOrder: User=%s, Order=%s: %v", username, orderID, err)
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Price must be greater than 0 for limit orders"})
+		w.WriteHeader(orderErrorStatus(err))
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
 
-	// For market orders, get current price
-	actualPrice := req.Price
-	if req.OrderType == "market" {
-		stockPrice, exists := h.storage.GetPrice(req.Symbol)
-		if !exists {
-			http.Error(w, "Stock not found", http.StatusNotFound)
-			return
-		}
-		// Round to 2 decimal places to avoid precision issues
-		actualPrice = math.Round(stockPrice.Price*100) / 100
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
+
+// AmendOrder changes the price or quantity of one of the user's open orders (protected)
+func (h *Handlers) AmendOrder(w http.ResponseWriter, r *http.Request) {
+	username, ok := r.Context().Value("username").(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	// Execute order with validation
-	var err error
-	if req.Side == "buy" {
-		err = h.storage.ExecuteBuyOrder(username, req.Symbol, req.Quantity, actualPrice, req.OrderType)
-	} else {
-		err = h.storage.ExecuteSellOrder(username, req.Symbol, req.Quantity, actualPrice, req.OrderType)
+	var req AmendOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if req.Price < 0 || req.Quantity < 0 || (req.Price == 0 && req.Quantity == 0) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "A positive price or quantity is required"})
+		return
 	}
 
+	orderID := mux.Vars(r)["id"]
+	original, err := h.storage.GetOrder(username, orderID)
 	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(orderErrorStatus(err))
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if err := h.checkSession(original.OrderType); err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
-
-	// Determine order status
-	orderStatus := "done" // Market orders are executed immediately
-	if req.OrderType == "limit" {
-		orderStatus = "pending" // Limit orders wait for price condition
+	if stockPrice, exists := h.storage.GetPrice(original.Symbol); exists {
+		req.Price = req.Price.Round(stockPrice.TickSize)
+		if req.Quantity > 0 {
+			if err := checkLotSize(stockPrice, req.Quantity); err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+		}
 	}
 
-	// Create new order
-	order := storage.Order{
-		ID:        uuid.New().String(),
-		Username:  username,
-		Symbol:    req.Symbol,
-		Side:      req.Side,
-		OrderType: req.OrderType,
-		Quantity:  req.Quantity,
-		Price:     actualPrice,
-		Status:    orderStatus,
-		CreatedAt: time.Now(),
+	order, err := h.storage.AmendOrder(username, orderID, req.Price, req.Quantity)
+	if err != nil {
+		log.Printf("AmendOrder: User=%s, Order=%s: %v", username, orderID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(orderErrorStatus(err))
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
 	}
 
-	// Store the order
-	h.storage.AddOrder(order)
-
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(order)
 }
 
-// GetOrders returns all orders for the authenticated user (protected)
-func (h *Handlers) GetOrders(w http.ResponseWriter, r *http.Request) {
-	// Get username from context (set by auth middleware)
-	username, ok := r.Context().Value("username").(string)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	orders := h.storage.GetOrders(username)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(orders)
+// orderErrorStatus maps storage order errors to HTTP status codes
+func orderErrorStatus(err error) int {
+	switch err {
+	case storage.ErrOrderNotFound:
+		return http.StatusNotFound
+	case storage.ErrNotOrderOwner:
+		return http.StatusForbidden
+	case storage.ErrOrderNotOpen:
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
 }
 
 // GetAccount returns the user's account information
@@ -366,30 +457,65 @@ func (h *Handlers) GetAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Return account info
-	response := map[string]interface{}{
-		"username":  account.Username,
-		"credits":   account.Credits,
-		"portfolio": account.Portfolio,
-	}
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(accountResponse(h.storage, account))
 }
 
-// HandleWebSocket handles WebSocket connections
+// accountResponse describes an account's balances, positions and P&L, as
+// GET /api/account returns them; available balances exclude what resting
+// orders hold
+func accountResponse(store storage.Store, account *storage.UserAccount) map[string]interface{} {
+	balances := account.Balances()
+	pnl := store.ProfitAndLoss(account)
+	response := map[string]interface{}{
+		"username":           account.Username,
+		"credits":            balances.Credits,
+		"availableCredits":   balances.AvailableCredits,
+		"reservedCredits":    balances.ReservedCredits,
+		"portfolio":          balances.Portfolio,
+		"availablePortfolio": balances.AvailablePortfolio,
+		"reservedPortfolio":  balances.ReservedPortfolio,
+		"accountType":        account.AccountType,
+		"costBasisMethod":    pnl.CostBasisMethod,
+		"positions":          pnl.Positions,
+		"realizedPnL":        pnl.RealizedPnL,
+		"unrealizedPnL":      pnl.UnrealizedPnL,
+		"totalPnL":           pnl.TotalPnL,
+	}
+	if account.IsMargin() {
+		response["margin"] = store.Margin(account)
+	}
+	return response
+}
+
+// HandleWebSocket handles WebSocket connections. A JWT in the token query
+// parameter or the Authorization header authenticates the connection for
+// its user's private account channel; clients can also send one later in
+// an auth message.
 func (h *Handlers) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if header := r.Header.Get("Authorization"); token == "" && strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+	username := ""
+	if token != "" {
+		claims, err := auth.ValidateToken(token)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or expired token"})
+			return
+		}
+		username = claims.Username
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
 
-	client := &websocket.Client{
-		Hub:  h.hub,
-		Conn: conn,
-		Send: make(chan []byte, 256),
-	}
+	client := websocket.NewClient(h.hub, conn, username)
 
 	client.Hub.Register <- client
 
@@ -397,3 +523,10 @@ func (h *Handlers) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	go client.WritePump()
 	go client.ReadPump()
 }
+
+// GetWebSocketStats reports the connected WebSocket clients and how many
+// messages each has lost to the slow consumer policy (admin)
+func (h *Handlers) GetWebSocketStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.hub.Stats())
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"stocks-backend/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// GetPriceHistory returns a symbol's timestamped price history. The query
+// takes an optional from/to range, each an RFC 3339 timestamp or Unix
+// seconds, and a resolution such as 1m or 1h to compact the ticks to.
+func (h *Handlers) GetPriceHistory(w http.ResponseWriter, r *http.Request) {
+	symbol := strings.ToUpper(mux.Vars(r)["symbol"])
+	query := r.URL.Query()
+
+	from, fromErr := parseTime(query.Get("from"))
+	to, toErr := parseTime(query.Get("to"))
+	if fromErr != nil || toErr != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "from and to must be RFC 3339 timestamps or Unix seconds"})
+		return
+	}
+	var resolution time.Duration
+	if value := query.Get("resolution"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "resolution must be a positive duration such as 1m or 1h"})
+			return
+		}
+		resolution = parsed
+	}
+
+	points, err := h.storage.PriceTicks(symbol, from, to, resolution)
+	if err == storage.ErrStockNotFound {
+		http.Error(w, "Stock not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"symbol": symbol,
+		"points": points,
+	})
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/simulation"
+	"stocks-backend/internal/storage"
+	"stocks-backend/internal/websocket"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// GetInstruments returns the reference data of every listed instrument
+func (h *Handlers) GetInstruments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.storage.Instruments())
+}
+
+// ListInstrument lists a new instrument and opens trading in it (admin)
+func (h *Handlers) ListInstrument(w http.ResponseWriter, r *http.Request) {
+	var req storage.Instrument
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if len(req.Model) > 0 {
+		if _, err := simulation.ParseModel(req.Model); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid model: " + err.Error()})
+			return
+		}
+	}
+
+	instrument, err := h.storage.ListInstrument(req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == storage.ErrInstrumentListed {
+			status = http.StatusConflict
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := h.hub.Publish(websocket.ChannelInstruments, instrument.Symbol, map[string]interface{}{
+		"type":       "instrumentListed",
+		"instrument": instrument,
+	}); err != nil {
+		log.Printf("Error broadcasting listing: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(instrument)
+}
+
+// DelistInstrument ends trading in an instrument, cancelling its open
+// orders and settling its positions in cash at the price query parameter,
+// or the last price (admin)
+func (h *Handlers) DelistInstrument(w http.ResponseWriter, r *http.Request) {
+	price := decimal.Zero
+	if value := r.URL.Query().Get("price"); value != "" {
+		parsed, err := decimal.Parse(value)
+		if err != nil || parsed <= 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "price must be a positive number"})
+			return
+		}
+		price = parsed
+	}
+
+	symbol := strings.ToUpper(mux.Vars(r)["symbol"])
+	delisting, err := h.storage.DelistInstrument(symbol, price)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == storage.ErrStockNotFound {
+			status = http.StatusNotFound
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := h.hub.Publish(websocket.ChannelInstruments, symbol, map[string]interface{}{
+		"type":       "instrumentDelisted",
+		"symbol":     symbol,
+		"price":      delisting.Price,
+		"delistedAt": delisting.DelistedAt,
+	}); err != nil {
+		log.Printf("Error broadcasting delisting: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(delisting)
+}
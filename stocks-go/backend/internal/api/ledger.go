@@ -0,0 +1,31 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// GetLedger returns the entries posted to the user's cash account, oldest
+// first, each with the resulting balance (protected)
+func (h *Handlers) GetLedger(w http.ResponseWriter, r *http.Request) {
+	username, ok := r.Context().Value("username").(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.storage.GetLedger(username))
+}
+
+// CheckLedger runs the ledger integrity check (protected). It responds with
+// 500 when the books do not balance.
+func (h *Handlers) CheckLedger(w http.ResponseWriter, r *http.Request) {
+	report := h.storage.CheckLedger()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Balanced {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"stocks-backend/internal/market"
+	"stocks-backend/internal/storage"
+	"strings"
+	"time"
+)
+
+// SetCalendar makes order entry follow the sessions of calendar. Without
+// one, orders are accepted at any time.
+func (h *Handlers) SetCalendar(calendar *market.Calendar) {
+	h.calendar = calendar
+}
+
+// GetMarketStatus returns the current session, its rules and when the next
+// session begins
+func (h *Handlers) GetMarketStatus(w http.ResponseWriter, r *http.Request) {
+	calendar := h.calendar
+	if calendar == nil {
+		calendar = market.AlwaysOpen()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(calendar.Status(time.Now()))
+}
+
+// GetHalts returns the symbols whose trading is halted by circuit breakers
+func (h *Handlers) GetHalts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.storage.Halts())
+}
+
+// checkSession returns an error if the current session does not accept
+// orders of orderType
+func (h *Handlers) checkSession(orderType string) error {
+	if h.calendar == nil {
+		return nil
+	}
+	now := time.Now()
+	rules := h.calendar.Rules(now)
+	if rules.Accepts(orderType) {
+		return nil
+	}
+	session := h.calendar.SessionAt(now)
+	if session == market.SessionClosed {
+		return errors.New("The market is closed")
+	}
+	if !rules.AcceptOrders {
+		return fmt.Errorf("Orders are not accepted in the %s session", session)
+	}
+	return fmt.Errorf("Only %s orders are accepted in the %s session", strings.Join(rules.OrderTypes, ", "), session)
+}
+
+// endOfDay returns when a DAY order placed at t expires
+func (h *Handlers) endOfDay(t time.Time) time.Time {
+	if h.calendar == nil {
+		return storage.EndOfDay(t)
+	}
+	return h.calendar.EndOfDay(t)
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errStockNotFound is returned by newOrder for unknown symbols
+var errStockNotFound = errors.New("Stock not found")
+
+// BracketOrderRequest represents an entry order submitted together with
+// take-profit and stop-loss exits on the opposite side
+type BracketOrderRequest struct {
+	OrderRequest
+	TakeProfit decimal.Decimal `json:"takeProfit"` // limit price of the take-profit exit
+	StopLoss   decimal.Decimal `json:"stopLoss"`   // stop price of the stop-loss exit
+}
+
+// OCOOrderRequest represents a one-cancels-other pair of exits that close
+// an existing position
+type OCOOrderRequest struct {
+	Symbol     string          `json:"symbol"`
+	Side       string          `json:"side"`
+	Quantity   int             `json:"quantity"`
+	TakeProfit decimal.Decimal `json:"takeProfit"` // limit price of the take-profit leg
+	StopLoss   decimal.Decimal `json:"stopLoss"`   // stop price of the stop-loss leg
+}
+
+// newOrder normalizes and validates an order request and builds the order
+func (h *Handlers) newOrder(username string, req OrderRequest) (storage.Order, error) {
+	// Normalize inputs (be lenient on casing/whitespace)
+	req.Symbol = strings.ToUpper(strings.TrimSpace(req.Symbol))
+	req.Side = strings.ToLower(strings.TrimSpace(req.Side))
+	req.OrderType = strings.ToLower(strings.TrimSpace(req.OrderType))
+	req.TimeInForce = strings.ToUpper(strings.TrimSpace(req.TimeInForce))
+	if req.TimeInForce == "" {
+		req.TimeInForce = "GTC"
+	}
+
+	// Validate input
+	if req.Symbol == "" {
+		return storage.Order{}, errors.New("Symbol is required")
+	}
+	if req.Side != "buy" && req.Side != "sell" {
+		return storage.Order{}, errors.New("Side must be 'buy' or 'sell'")
+	}
+	switch req.OrderType {
+	case "market", "limit", "stop", "stop_limit", "trailing_stop":
+	default:
+		return storage.Order{}, errors.New("OrderType must be 'market', 'limit', 'stop', 'stop_limit' or 'trailing_stop'")
+	}
+
+	if err := h.checkSession(req.OrderType); err != nil {
+		return storage.Order{}, err
+	}
+
+	// Prices are rounded to the symbol's tick size before validation
+	stockPrice, exists := h.storage.GetPrice(req.Symbol)
+	if !exists {
+		return storage.Order{}, errStockNotFound
+	}
+	req.Price = req.Price.Round(stockPrice.TickSize)
+	req.StopPrice = req.StopPrice.Round(stockPrice.TickSize)
+	req.TrailAmount = req.TrailAmount.Round(stockPrice.TickSize)
+	if req.Quantity <= 0 {
+		return storage.Order{}, errors.New("Quantity must be greater than 0")
+	}
+	if err := checkLotSize(stockPrice, req.Quantity); err != nil {
+		return storage.Order{}, err
+	}
+	if (req.OrderType == "limit" || req.OrderType == "stop_limit") && req.Price <= 0 {
+		return storage.Order{}, errors.New("Price must be greater than 0 for limit orders")
+	}
+	if (req.OrderType == "stop" || req.OrderType == "stop_limit") && req.StopPrice <= 0 {
+		return storage.Order{}, errors.New("StopPrice must be greater than 0 for stop orders")
+	}
+	if req.OrderType == "trailing_stop" {
+		validAmount := req.TrailAmount > 0 && req.TrailPercent == 0
+		validPercent := req.TrailPercent > 0 && req.TrailPercent < 100 && req.TrailAmount == 0
+		if !validAmount && !validPercent {
+			return storage.Order{}, errors.New("Trailing stops need either trailAmount > 0 or trailPercent between 0 and 100")
+		}
+	}
+
+	// DAY orders expire at the end of the trading day, GTD orders at the
+	// requested time; other orders never expire
+	var expiresAt *time.Time
+	switch req.TimeInForce {
+	case "GTC", "IOC", "FOK":
+	case "DAY":
+		endOfDay := h.endOfDay(time.Now())
+		expiresAt = &endOfDay
+	case "GTD":
+		if req.ExpiresAt == nil || !req.ExpiresAt.After(time.Now()) {
+			return storage.Order{}, errors.New("GTD orders need an expiresAt in the future")
+		}
+		expiresAt = req.ExpiresAt
+	default:
+		return storage.Order{}, errors.New("TimeInForce must be 'GTC', 'DAY', 'GTD', 'IOC' or 'FOK'")
+	}
+
+	// Stop (market) orders have no price until they trigger, and trailing
+	// stops compute their own stop price
+	actualPrice := req.Price
+	stopPrice := req.StopPrice
+	if req.OrderType == "stop" || req.OrderType == "trailing_stop" {
+		actualPrice = 0
+	}
+	if req.OrderType == "trailing_stop" {
+		stopPrice = 0
+	}
+
+	// For market orders, get current price
+	if req.OrderType == "market" {
+		actualPrice = stockPrice.Price
+	}
+
+	return storage.Order{
+		ID:        uuid.New().String(),
+		Username:  username,
+		Symbol:    req.Symbol,
+		Side:      req.Side,
+		OrderType: req.OrderType,
+		Quantity:  req.Quantity,
+		Price:     actualPrice,
+		StopPrice: stopPrice,
+		CreatedAt: time.Now(),
+
+		TrailAmount:  req.TrailAmount,
+		TrailPercent: req.TrailPercent,
+
+		TimeInForce: req.TimeInForce,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+// newExits builds the take-profit limit and stop-loss stop that close a
+// position of quantity on side, with prices rounded to the symbol's tick size
+func (h *Handlers) newExits(username, symbol, side string, quantity int, takeProfit, stopLoss decimal.Decimal) (storage.Order, storage.Order, error) {
+	for _, orderType := range []string{"limit", "stop"} {
+		if err := h.checkSession(orderType); err != nil {
+			return storage.Order{}, storage.Order{}, err
+		}
+	}
+
+	stockPrice, exists := h.storage.GetPrice(symbol)
+	if !exists {
+		return storage.Order{}, storage.Order{}, errStockNotFound
+	}
+	takeProfit = takeProfit.Round(stockPrice.TickSize)
+	stopLoss = stopLoss.Round(stockPrice.TickSize)
+	if takeProfit <= 0 || stopLoss <= 0 {
+		return storage.Order{}, storage.Order{}, errors.New("takeProfit and stopLoss must be greater than 0")
+	}
+	if err := checkLotSize(stockPrice, quantity); err != nil {
+		return storage.Order{}, storage.Order{}, err
+	}
+
+	now := time.Now()
+	exit := storage.Order{
+		Username:    username,
+		Symbol:      symbol,
+		Side:        side,
+		Quantity:    quantity,
+		CreatedAt:   now,
+		TimeInForce: "GTC",
+	}
+
+	tp := exit
+	tp.ID = uuid.New().String()
+	tp.OrderType = "limit"
+	tp.Price = takeProfit
+
+	sl := exit
+	sl.ID = uuid.New().String()
+	sl.OrderType = "stop"
+	sl.StopPrice = stopLoss
+
+	return tp, sl, nil
+}
+
+// checkLotSize returns an error unless quantity is a whole number of the
+// symbol's lots
+func checkLotSize(stockPrice *storage.StockPrice, quantity int) error {
+	if stockPrice.LotSize > 1 && quantity%stockPrice.LotSize != 0 {
+		return fmt.Errorf("Quantity must be a multiple of the lot size of %d", stockPrice.LotSize)
+	}
+	return nil
+}
+
+// writeOrderRequestError writes a validation or storage error for an order request
+func writeOrderRequestError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if err == errStockNotFound {
+		status = http.StatusNotFound
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// CreateBracketOrder submits an entry order with take-profit and stop-loss
+// exits as one group (protected)
+func (h *Handlers) CreateBracketOrder(w http.ResponseWriter, r *http.Request) {
+	username, ok := r.Context().Value("username").(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req BracketOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOrderRequestError(w, errors.New("Invalid request body"))
+		return
+	}
+
+	entry, err := h.newOrder(username, req.OrderRequest)
+	if err != nil {
+		writeOrderRequestError(w, err)
+		return
+	}
+	exitSide := "sell"
+	if entry.Side == "sell" {
+		exitSide = "buy"
+	}
+	takeProfit, stopLoss, err := h.newExits(username, entry.Symbol, exitSide, entry.Quantity, req.TakeProfit, req.StopLoss)
+	if err != nil {
+		writeOrderRequestError(w, err)
+		return
+	}
+
+	groupID := uuid.New().String()
+	orders, err := h.storage.SubmitBracket(groupID, &entry, &takeProfit, &stopLoss)
+	if err != nil {
+		log.Printf("CreateBracketOrder: User=%s: %v", username, err)
+		writeOrderRequestError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"groupId": groupID,
+		"orders":  orders,
+	})
+}
+
+// CreateOCOOrder submits a one-cancels-other take-profit/stop-loss pair (protected)
+func (h *Handlers) CreateOCOOrder(w http.ResponseWriter, r *http.Request) {
+	username, ok := r.Context().Value("username").(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req OCOOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOrderRequestError(w, errors.New("Invalid request body"))
+		return
+	}
+
+	req.Symbol = strings.ToUpper(strings.TrimSpace(req.Symbol))
+	req.Side = strings.ToLower(strings.TrimSpace(req.Side))
+	if req.Symbol == "" {
+		writeOrderRequestError(w, errors.New("Symbol is required"))
+		return
+	}
+	if req.Side != "buy" && req.Side != "sell" {
+		writeOrderRequestError(w, errors.New("Side must be 'buy' or 'sell'"))
+		return
+	}
+	if req.Quantity <= 0 {
+		writeOrderRequestError(w, errors.New("Quantity must be greater than 0"))
+		return
+	}
+
+	takeProfit, stopLoss, err := h.newExits(username, req.Symbol, req.Side, req.Quantity, req.TakeProfit, req.StopLoss)
+	if err != nil {
+		writeOrderRequestError(w, err)
+		return
+	}
+
+	groupID := uuid.New().String()
+	orders, err := h.storage.SubmitOCO(groupID, &takeProfit, &stopLoss)
+	if err != nil {
+		log.Printf("CreateOCOOrder: User=%s: %v", username, err)
+		writeOrderRequestError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"groupId": groupID,
+		"orders":  orders,
+	})
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"stocks-backend/internal/simulation"
+	"time"
+)
+
+// ReplayRequest controls a historical replay. Omitted fields are unchanged.
+type ReplayRequest struct {
+	Paused *bool      `json:"paused"`
+	Speed  string     `json:"speed"` // such as "1x", "10x" or "max"
+	Loop   *bool      `json:"loop"`
+	Seek   *time.Time `json:"seek"` // jump to the first bar at or after this time
+}
+
+// SetReplay enables the replay endpoints for a server replaying history
+func (h *Handlers) SetReplay(replay *simulation.Replay) {
+	h.replay = replay
+}
+
+// GetReplay reports the historical replay's position and settings (protected)
+func (h *Handlers) GetReplay(w http.ResponseWriter, r *http.Request) {
+	if h.replay == nil {
+		http.Error(w, "Not replaying history", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.replay.Status())
+}
+
+// ControlReplay pauses, resumes, seeks or changes the speed of the
+// historical replay (admin)
+func (h *Handlers) ControlReplay(w http.ResponseWriter, r *http.Request) {
+	if h.replay == nil {
+		http.Error(w, "Not replaying history", http.StatusNotFound)
+		return
+	}
+
+	var req ReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+	speed := -1.0
+	if req.Speed != "" {
+		parsed, err := simulation.ParseSpeed(req.Speed)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		speed = parsed
+	}
+
+	if speed >= 0 {
+		h.replay.SetSpeed(speed)
+	}
+	if req.Loop != nil {
+		h.replay.SetLoop(*req.Loop)
+	}
+	if req.Seek != nil {
+		h.replay.Seek(*req.Seek)
+	}
+	if req.Paused != nil {
+		if *req.Paused {
+			h.replay.Pause()
+		} else {
+			h.replay.Resume()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.replay.Status())
+}
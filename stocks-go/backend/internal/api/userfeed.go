@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"stocks-backend/internal/storage"
+	"stocks-backend/internal/websocket"
+	"time"
+)
+
+// UserFeed pushes each user's order and account changes to their
+// authenticated WebSocket clients on the private account channel.
+//
+// Order changes are sent as they happen as
+// {"type": "order", "event": "accepted", "order": {...}}, where event is
+// accepted, updated, partiallyFilled, filled, cancelled, expired, rejected
+// or replaced. Account changes are gathered and sent every interval as
+// {"type": "account", "account": {...}}, with the fields of GET /api/account.
+type UserFeed struct {
+	storage storage.Store
+	hub     *websocket.Hub
+	events  chan storage.UserEvent
+	ticker  *time.Ticker
+	done    chan struct{}
+
+	sent    map[string]sentOrder // last state sent of each order, to skip repeats
+	changed map[string]bool      // users whose accounts changed since the last send
+}
+
+// sentOrder is the last state of an order a UserFeed sent
+type sentOrder struct {
+	data []byte
+	open bool
+}
+
+// NewUserFeed creates a feed of store's user events that sends account
+// changes every interval
+func NewUserFeed(store storage.Store, hub *websocket.Hub, interval time.Duration) *UserFeed {
+	return &UserFeed{
+		storage: store,
+		hub:     hub,
+		events:  make(chan storage.UserEvent, 1024),
+		ticker:  time.NewTicker(interval),
+		done:    make(chan struct{}),
+		sent:    make(map[string]sentOrder),
+		changed: make(map[string]bool),
+	}
+}
+
+// Start subscribes to the store's user events and begins sending them in
+// the background
+func (f *UserFeed) Start() {
+	f.storage.NotifyUserEvents(f.events)
+	go func() {
+		for {
+			select {
+			case event := <-f.events:
+				if event.Order != nil {
+					f.sendOrder(*event.Order)
+				} else {
+					f.changed[event.Username] = true
+				}
+			case <-f.ticker.C:
+				f.sendAccounts()
+			case <-f.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the feed
+func (f *UserFeed) Stop() {
+	f.storage.NotifyUserEvents(nil)
+	f.ticker.Stop()
+	close(f.done)
+}
+
+// sendOrder sends an order's new state to its user, unless it is the state
+// last sent
+func (f *UserFeed) sendOrder(order storage.Order) {
+	data, err := json.Marshal(order)
+	if err != nil {
+		log.Printf("Error encoding order %s: %v", order.ID, err)
+		return
+	}
+	if bytes.Equal(f.sent[order.ID].data, data) {
+		return
+	}
+	f.sent[order.ID] = sentOrder{data: data, open: order.IsOpen()}
+
+	if !f.hub.Watching(order.Username) {
+		return
+	}
+	if err := f.hub.PublishUser(order.Username, map[string]interface{}{
+		"type":  "order",
+		"event": orderEvent(order),
+		"order": json.RawMessage(data),
+	}); err != nil {
+		log.Printf("Error sending order event: %v", err)
+	}
+}
+
+// sendAccounts sends the accounts that changed to their users and forgets
+// the orders that can no longer change
+func (f *UserFeed) sendAccounts() {
+	for username := range f.changed {
+		delete(f.changed, username)
+		if !f.hub.Watching(username) {
+			continue
+		}
+		account := f.storage.GetAccount(username)
+		if account == nil {
+			continue
+		}
+		if err := f.hub.PublishUser(username, map[string]interface{}{
+			"type":    "account",
+			"account": accountResponse(f.storage, account),
+		}); err != nil {
+			log.Printf("Error sending account event: %v", err)
+		}
+	}
+
+	// Closed orders are kept until now so that repeats of their last state
+	// from the same operation are skipped
+	for id, sent := range f.sent {
+		if !sent.open {
+			delete(f.sent, id)
+		}
+	}
+}
+
+// orderEvent names the change that left an order in its state
+func orderEvent(order storage.Order) string {
+	switch order.Status {
+	case "done":
+		return "filled"
+	case "partially_filled":
+		return "partiallyFilled"
+	case "cancelled", "expired", "rejected", "replaced":
+		return order.Status
+	}
+	if order.UpdatedAt == nil && order.TriggeredAt == nil {
+		return "accepted"
+	}
+	return "updated"
+}
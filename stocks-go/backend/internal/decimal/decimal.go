@@ -0,0 +1,240 @@
+package decimal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an exact fixed-point number with four decimal places, stored
+// as a whole number of ten-thousandths. It is used for prices and amounts of
+// cash. Addition, subtraction and comparison work with the usual operators
+// and, like multiplying by a whole quantity, are exact. Operations whose
+// result can need more places round half away from zero.
+//
+// Decimals encode to JSON as plain numbers with no trailing zeros, such as
+// 150.25 or 2000, and decode from JSON numbers or numeric strings.
+type Decimal int64
+
+// Places is the number of decimal places a Decimal holds
+const Places = 4
+
+const scale = 10000
+
+// Common values
+const (
+	Zero Decimal = 0
+	Cent Decimal = scale / 100
+	One  Decimal = scale
+)
+
+var errSyntax = errors.New("invalid decimal")
+
+// New returns value scaled down by places decimal places, so New(15025, 2)
+// is 150.25. Places beyond four are rounded.
+func New(value int64, places int) Decimal {
+	if places <= Places {
+		return Decimal(value * int64(math.Pow10(Places-places)))
+	}
+	return Decimal(roundQuo(big.NewInt(value), big.NewInt(int64(math.Pow10(places-Places)))))
+}
+
+// FromInt returns n as a Decimal
+func FromInt(n int64) Decimal {
+	return Decimal(n * scale)
+}
+
+// FromFloat returns f rounded to four decimal places
+func FromFloat(f float64) Decimal {
+	return Decimal(math.Round(f * scale))
+}
+
+// Parse reads a decimal number such as "150.25", "-3" or "1e-3", rounding
+// it to four decimal places
+func Parse(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, errSyntax
+		}
+		return FromFloat(f), nil
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return 0, errSyntax
+	}
+	if !digitsOnly(whole) || !digitsOnly(fraction) {
+		return 0, errSyntax
+	}
+
+	roundUp := false
+	if len(fraction) > Places {
+		roundUp = fraction[Places] >= '5'
+		fraction = fraction[:Places]
+	}
+	fraction += strings.Repeat("0", Places-len(fraction))
+
+	var w, f uint64
+	var err error
+	if whole != "" {
+		if w, err = strconv.ParseUint(whole, 10, 64); err != nil || w > math.MaxInt64/scale-1 {
+			return 0, errSyntax
+		}
+	}
+	if f, err = strconv.ParseUint(fraction, 10, 64); err != nil {
+		return 0, errSyntax
+	}
+
+	d := Decimal(w*scale + f)
+	if roundUp {
+		d++
+	}
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
+func digitsOnly(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Float64 returns the nearest float64, for ratios and display
+func (d Decimal) Float64() float64 {
+	return float64(d) / scale
+}
+
+// String formats d with no trailing zeros, such as "150.25" or "-3"
+func (d Decimal) String() string {
+	sign := ""
+	units := uint64(d)
+	if d < 0 {
+		sign = "-"
+		units = uint64(-d)
+	}
+
+	whole, fraction := units/scale, units%scale
+	if fraction == 0 {
+		return sign + strconv.FormatUint(whole, 10)
+	}
+	digits := strings.TrimRight(fmt.Sprintf("%0*d", Places, fraction), "0")
+	return sign + strconv.FormatUint(whole, 10) + "." + digits
+}
+
+// MulInt returns d times a whole quantity, exactly
+func (d Decimal) MulInt(n int) Decimal {
+	return d * Decimal(n)
+}
+
+// DivInt returns d divided by a whole quantity, rounded
+func (d Decimal) DivInt(n int) Decimal {
+	return Decimal(roundQuo(big.NewInt(int64(d)), big.NewInt(int64(n))))
+}
+
+// Mul returns d times e, rounded
+func (d Decimal) Mul(e Decimal) Decimal {
+	product := new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(int64(e)))
+	return Decimal(roundQuo(product, big.NewInt(scale)))
+}
+
+// Div returns d divided by e, rounded
+func (d Decimal) Div(e Decimal) Decimal {
+	dividend := new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(scale))
+	return Decimal(roundQuo(dividend, big.NewInt(int64(e))))
+}
+
+// MulFloat returns d scaled by a ratio such as a percentage, rounded
+func (d Decimal) MulFloat(f float64) Decimal {
+	return FromFloat(d.Float64() * f)
+}
+
+// Quo returns how many whole times e fits into d, truncated toward zero
+func (d Decimal) Quo(e Decimal) int {
+	return int(d / e)
+}
+
+// Ratio returns d / e as a float64, for percentages and other ratios
+func (d Decimal) Ratio(e Decimal) float64 {
+	return float64(d) / float64(e)
+}
+
+// Round returns the multiple of tick nearest to d, rounding half away
+// from zero. A tick of zero or less leaves d unchanged.
+func (d Decimal) Round(tick Decimal) Decimal {
+	if tick <= 0 {
+		return d
+	}
+	return Decimal(roundQuo(big.NewInt(int64(d)), big.NewInt(int64(tick)))) * tick
+}
+
+// Abs returns the absolute value of d
+func (d Decimal) Abs() Decimal {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Max returns the larger of a and b
+func Max(a, b Decimal) Decimal {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Min returns the smaller of a and b
+func Min(a, b Decimal) Decimal {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// roundQuo divides a by b, rounding half away from zero
+func roundQuo(a, b *big.Int) int64 {
+	q, r := new(big.Int).QuoRem(a, b, new(big.Int))
+	// |2r| >= |b| means the remainder is at least half way
+	if r.Sign() != 0 && new(big.Int).Abs(new(big.Int).Lsh(r, 1)).Cmp(new(big.Int).Abs(b)) >= 0 {
+		if (a.Sign() < 0) != (b.Sign() < 0) {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q.Int64()
+}
+
+// MarshalJSON encodes d as a JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON decodes a JSON number or numeric string; null leaves d unchanged
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if text == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		text = unquoted
+	}
+	parsed, err := Parse(text)
+	if err != nil {
+		return fmt.Errorf("decimal: cannot decode %s", data)
+	}
+	*d = parsed
+	return nil
+}
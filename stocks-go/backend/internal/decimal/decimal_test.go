@@ -0,0 +1,187 @@
+package decimal
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Decimal
+		err  bool
+	}{
+		{in: "150.25", want: 1502500},
+		{in: "-3", want: -30000},
+		{in: "+7.5", want: 75000},
+		{in: ".5", want: 5000},
+		{in: "5.", want: 50000},
+		{in: " 2000 ", want: 20000000},
+		{in: "0.0001", want: 1},
+		{in: "0.00005", want: 1},
+		{in: "0.00004", want: 0},
+		{in: "-0.00005", want: -1},
+		{in: "1.99995", want: 20000},
+		{in: "1e-3", want: 10},
+		{in: "2.5E2", want: 2500000},
+		{in: "", err: true},
+		{in: ".", err: true},
+		{in: "-", err: true},
+		{in: "1.2.3", err: true},
+		{in: "12a", err: true},
+		{in: "1 000", err: true},
+		{in: "99999999999999999999", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %v, %v, want %v", tt.in, int64(got), err, int64(tt.want))
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		in   Decimal
+		want string
+	}{
+		{0, "0"},
+		{1502500, "150.25"},
+		{20000000, "2000"},
+		{-30000, "-3"},
+		{1, "0.0001"},
+		{-1, "-0.0001"},
+		{-5000, "-0.5"},
+		{123456789, "12345.6789"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Decimal(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		value  int64
+		places int
+		want   string
+	}{
+		{15025, 2, "150.25"},
+		{3, 0, "3"},
+		{-15025, 2, "-150.25"},
+		{123456, 5, "1.2346"},
+		{123455, 5, "1.2346"},
+		{-123455, 5, "-1.2346"},
+		{123454, 5, "1.2345"},
+	}
+	for _, tt := range tests {
+		if got := New(tt.value, tt.places).String(); got != tt.want {
+			t.Errorf("New(%d, %d) = %s, want %s", tt.value, tt.places, got, tt.want)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	d := func(s string) Decimal {
+		v, err := Parse(s)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", s, err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name string
+		got  Decimal
+		want string
+	}{
+		{"add is exact", d("0.1") + d("0.2"), "0.3"},
+		{"sub is exact", d("150.25") - d("0.26"), "149.99"},
+		{"MulInt", d("150.25").MulInt(3), "450.75"},
+		{"MulInt negative", d("-0.0001").MulInt(7), "-0.0007"},
+		{"DivInt exact", d("1").DivInt(8), "0.125"},
+		{"DivInt rounds to 4 places", d("10").DivInt(3), "3.3333"},
+		{"DivInt rounds half away from zero", d("0.0003").DivInt(2), "0.0002"},
+		{"DivInt negative", d("-0.0003").DivInt(2), "-0.0002"},
+		{"Mul", d("150.25").Mul(d("0.001")), "0.1503"},
+		{"Mul negative", d("-150.25").Mul(d("0.001")), "-0.1503"},
+		{"Mul large", d("900000000").Mul(d("100")), "90000000000"},
+		{"Div", d("1").Div(d("3")), "0.3333"},
+		{"Div rounds", d("2").Div(d("3")), "0.6667"},
+		{"Div negative", d("-2").Div(d("3")), "-0.6667"},
+		{"MulFloat", d("200").MulFloat(0.015), "3"},
+		{"Round to cent", d("150.255").Round(Cent), "150.26"},
+		{"Round down to cent", d("150.2549").Round(Cent), "150.25"},
+		{"Round negative", d("-150.255").Round(Cent), "-150.26"},
+		{"Round to 0.05", d("10.07").Round(d("0.05")), "10.05"},
+		{"Round to 0.05 half", d("10.075").Round(d("0.05")), "10.1"},
+		{"Round by zero tick", d("10.0701").Round(0), "10.0701"},
+		{"Abs", d("-3.5").Abs(), "3.5"},
+		{"Max", Max(d("1"), d("-2")), "1"},
+		{"Min", Min(d("1"), d("-2")), "-2"},
+	}
+	for _, tt := range tests {
+		if got := tt.got.String(); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if got := d("10.5").Quo(d("3")); got != 3 {
+		t.Errorf("Quo = %d, want 3", got)
+	}
+	if got := d("-10.5").Quo(d("3")); got != -3 {
+		t.Errorf("Quo negative = %d, want -3", got)
+	}
+	if got := d("1").Ratio(d("4")); got != 0.25 {
+		t.Errorf("Ratio = %v, want 0.25", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Decimal
+		out  string
+		err  bool
+	}{
+		{in: `150.25`, want: 1502500, out: `150.25`},
+		{in: `"150.25"`, want: 1502500, out: `150.25`},
+		{in: `2000`, want: 20000000, out: `2000`},
+		{in: `-0.5`, want: -5000, out: `-0.5`},
+		{in: `1e2`, want: 1000000, out: `100`},
+		{in: `"abc"`, err: true},
+		{in: `true`, err: true},
+	}
+	for _, tt := range tests {
+		var got Decimal
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Unmarshal(%s) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, %v, want %v", tt.in, got, err, tt.want)
+			continue
+		}
+		out, err := json.Marshal(got)
+		if err != nil || string(out) != tt.out {
+			t.Errorf("Marshal(%v) = %s, %v, want %s", got, out, err, tt.out)
+		}
+	}
+
+	// null leaves the value as it was
+	got := Decimal(42)
+	if err := json.Unmarshal([]byte(`null`), &got); err != nil || got != 42 {
+		t.Errorf("Unmarshal(null) = %v, %v, want 42 unchanged", int64(got), err)
+	}
+}
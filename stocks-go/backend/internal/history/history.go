@@ -0,0 +1,362 @@
+package history
+
+import (
+	"sort"
+	"stocks-backend/internal/decimal"
+	"sync"
+	"time"
+)
+
+// Point is a price observed at a moment, or once compacted, a summary of
+// the ticks observed in a period
+type Point struct {
+	Time  time.Time       `json:"time"`  // when the tick was observed, or the start of the period
+	Price decimal.Decimal `json:"price"` // last price
+	High  decimal.Decimal `json:"high"`
+	Low   decimal.Decimal `json:"low"`
+	Count int             `json:"count"` // ticks summarized
+}
+
+// merge folds later, which follows p in time, into p
+func (p Point) merge(later Point) Point {
+	p.Price = later.Price
+	p.High = decimal.Max(p.High, later.High)
+	p.Low = decimal.Min(p.Low, later.Low)
+	p.Count += later.Count
+	return p
+}
+
+// Tier is a level of compacted history: points one Resolution apart, of
+// which Capacity are kept
+type Tier struct {
+	Resolution time.Duration
+	Capacity   int
+}
+
+// Config sizes a Store: Capacity raw ticks per symbol, then each tier in
+// turn, from finest to coarsest
+type Config struct {
+	Capacity int
+	Tiers    []Tier
+}
+
+// DefaultConfig keeps a day of raw ticks at the simulator's 3 second tick,
+// a month of 1 minute points and two years of hourly points, at most about
+// 5 MB per symbol
+var DefaultConfig = Config{
+	Capacity: 24 * 60 * 20,
+	Tiers: []Tier{
+		{Resolution: time.Minute, Capacity: 30 * 24 * 60},
+		{Resolution: time.Hour, Capacity: 2 * 365 * 24},
+	},
+}
+
+// Store keeps a bounded, timestamped price history per symbol. The newest
+// ticks are kept as they were observed in a ring buffer. When the buffer is
+// full, the oldest tick is compacted into the first tier, whose oldest point
+// is compacted into the next tier when it is full in turn, and so on; the
+// last tier drops its oldest points. Buffers grow as points arrive, up to
+// their capacity, so memory use is bounded by the Config while the covered
+// time span grows with each tier's resolution.
+type Store struct {
+	config Config
+	series map[string][]*ring // symbol -> raw ticks, then one ring per tier
+	mutex  sync.RWMutex
+}
+
+// NewStore creates an empty Store sized by config
+func NewStore(config Config) *Store {
+	return &Store{
+		config: config,
+		series: make(map[string][]*ring),
+	}
+}
+
+// levels returns the rings of a symbol, creating them if needed.
+// Callers must hold the write lock.
+func (s *Store) levels(symbol string) []*ring {
+	levels, exists := s.series[symbol]
+	if !exists {
+		levels = make([]*ring, 0, len(s.config.Tiers)+1)
+		levels = append(levels, newRing(s.config.Capacity))
+		for _, tier := range s.config.Tiers {
+			levels = append(levels, newRing(tier.Capacity))
+		}
+		s.series[symbol] = levels
+	}
+	return levels
+}
+
+// resolution returns the resolution of a level; raw ticks have none
+func (s *Store) resolution(level int) time.Duration {
+	if level == 0 {
+		return 0
+	}
+	return s.config.Tiers[level-1].Resolution
+}
+
+// Add records price as observed at the given time. Ticks that are not newer
+// than the latest one recorded for the symbol are ignored, so replaying
+// history that is already stored is harmless.
+func (s *Store) Add(symbol string, at time.Time, price decimal.Decimal) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	levels := s.levels(symbol)
+	if latest, ok := levels[0].last(); ok && !at.After(latest.Time) {
+		return
+	}
+	s.insert(levels, 0, Point{Time: at.UTC(), Price: price, High: price, Low: price, Count: 1})
+}
+
+// insert adds p, which is newer than everything at level, compacting
+// whatever it evicts into the next level. Callers must hold the write lock.
+func (s *Store) insert(levels []*ring, level int, p Point) {
+	r := levels[level]
+	if resolution := s.resolution(level); resolution > 0 {
+		p.Time = p.Time.Truncate(resolution)
+		if last, ok := r.last(); ok && last.Time.Equal(p.Time) {
+			r.replaceLast(last.merge(p))
+			return
+		}
+	}
+
+	evicted, ok := r.push(p)
+	if ok && level+1 < len(levels) {
+		s.insert(levels, level+1, evicted)
+	}
+}
+
+// Latest returns the newest tick recorded for symbol
+func (s *Store) Latest(symbol string) (Point, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	levels, exists := s.series[symbol]
+	if !exists {
+		return Point{}, false
+	}
+	return levels[0].last()
+}
+
+// Range returns the history of symbol in [from, to), oldest first. A zero
+// from or to leaves that end open. Each period is served at the finest
+// resolution still held for it, so raw ticks come last. A resolution above
+// zero compacts finer points further into periods of that length.
+func (s *Store) Range(symbol string, from, to time.Time, resolution time.Duration) []Point {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	points := make([]Point, 0)
+	levels, exists := s.series[symbol]
+	if !exists {
+		return points
+	}
+
+	// Coarser levels hold older periods and finer levels take over from
+	// where they begin
+	end := to
+	for level := 0; level < len(levels); level++ {
+		r := levels[level]
+		if r.size == 0 {
+			continue
+		}
+		first, last := r.search(from), r.size
+		if !end.IsZero() {
+			last = r.search(end)
+		}
+		if first < last {
+			chunk := make([]Point, 0, last-first)
+			for i := first; i < last; i++ {
+				chunk = append(chunk, r.at(i))
+			}
+			points = append(chunk, points...)
+		}
+		if oldest := r.at(0).Time; end.IsZero() || oldest.Before(end) {
+			end = oldest
+		}
+		if !from.IsZero() && !end.After(from) {
+			break
+		}
+	}
+
+	if resolution > 0 {
+		points = compact(points, resolution)
+	}
+	return points
+}
+
+// compact merges consecutive points that fall in the same period of length
+// resolution
+func compact(points []Point, resolution time.Duration) []Point {
+	compacted := make([]Point, 0, len(points))
+	for _, p := range points {
+		p.Time = p.Time.Truncate(resolution)
+		if n := len(compacted); n > 0 && compacted[n-1].Time.Equal(p.Time) {
+			compacted[n-1] = compacted[n-1].merge(p)
+			continue
+		}
+		compacted = append(compacted, p)
+	}
+	return compacted
+}
+
+// Levels returns everything held for symbol: the raw ticks, then each
+// tier's points, each oldest first. Restore takes it back.
+func (s *Store) Levels(symbol string) [][]Point {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	levels := s.series[symbol]
+	dump := make([][]Point, len(levels))
+	for level, r := range levels {
+		dump[level] = make([]Point, 0, r.size)
+		for i := 0; i < r.size; i++ {
+			dump[level] = append(dump[level], r.at(i))
+		}
+	}
+	return dump
+}
+
+// Restore replaces the history of symbol with levels from Levels. Points
+// beyond the current Config's capacity are compacted or dropped as usual.
+func (s *Store) Restore(symbol string, levels [][]Point) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.series, symbol)
+	rings := s.levels(symbol)
+	// Coarser levels are older, so they go in first
+	for level := len(levels) - 1; level >= 0; level-- {
+		target := level
+		if target >= len(rings) {
+			target = len(rings) - 1
+		}
+		for _, p := range levels[level] {
+			if last, ok := rings[target].last(); ok && p.Time.Before(last.Time) {
+				continue
+			}
+			s.insert(rings, target, p)
+		}
+	}
+}
+
+// Truncate drops the ticks of symbol from the given time on, and the
+// compacted points of periods that end after it, so that ticks from that
+// time on can be added again, as when a replayed feed goes back in time
+func (s *Store) Truncate(symbol string, from time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for level, r := range s.series[symbol] {
+		resolution := s.resolution(level)
+		keep := r.size
+		for ; keep > 0; keep-- {
+			p := r.at(keep - 1)
+			if resolution == 0 && p.Time.Before(from) || resolution > 0 && !p.Time.Add(resolution).After(from) {
+				break
+			}
+		}
+		r.truncate(keep)
+	}
+}
+
+// Adjust rewrites every price held for symbol with adjust, as when a stock
+// split rescales the history
+func (s *Store) Adjust(symbol string, adjust func(decimal.Decimal) decimal.Decimal) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, r := range s.series[symbol] {
+		for i := 0; i < r.size; i++ {
+			p := &r.points[(r.head+i)%len(r.points)]
+			p.Price, p.High, p.Low = adjust(p.Price), adjust(p.High), adjust(p.Low)
+		}
+	}
+}
+
+// Symbols returns the symbols with history
+func (s *Store) Symbols() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	symbols := make([]string, 0, len(s.series))
+	for symbol := range s.series {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// ringGrowth is the fewest points a ring allocates room for at once
+const ringGrowth = 64
+
+// ring is a bounded circular buffer of points in time order. It grows as
+// points arrive until it holds capacity points, and only then wraps around.
+type ring struct {
+	points   []Point
+	head     int // index of the oldest point
+	size     int
+	capacity int
+}
+
+func newRing(capacity int) *ring {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ring{capacity: capacity}
+}
+
+// at returns the i-th oldest point
+func (r *ring) at(i int) Point {
+	return r.points[(r.head+i)%len(r.points)]
+}
+
+func (r *ring) last() (Point, bool) {
+	if r.size == 0 {
+		return Point{}, false
+	}
+	return r.at(r.size - 1), true
+}
+
+func (r *ring) replaceLast(p Point) {
+	r.points[(r.head+r.size-1)%len(r.points)] = p
+}
+
+// push appends p, returning the oldest point if it had to be evicted
+func (r *ring) push(p Point) (Point, bool) {
+	if r.size < r.capacity {
+		// Until the ring is full nothing is evicted, so head is 0 and the
+		// points fill the slice
+		if len(r.points) == cap(r.points) {
+			grown := make([]Point, len(r.points), min(max(2*len(r.points), ringGrowth), r.capacity))
+			copy(grown, r.points)
+			r.points = grown
+		}
+		r.points = append(r.points, p)
+		r.size++
+		return Point{}, false
+	}
+	evicted := r.points[r.head]
+	r.points[r.head] = p
+	r.head = (r.head + 1) % len(r.points)
+	return evicted, true
+}
+
+// truncate keeps the n oldest points. The ring is rebuilt from its oldest
+// point, as push expects of a ring that is not full.
+func (r *ring) truncate(n int) {
+	if n >= r.size {
+		return
+	}
+	points := make([]Point, n)
+	for i := range points {
+		points[i] = r.at(i)
+	}
+	r.points, r.head, r.size = points, 0, n
+}
+
+// search returns the index of the first point at or after t
+func (r *ring) search(t time.Time) int {
+	return sort.Search(r.size, func(i int) bool { return !r.at(i).Time.Before(t) })
+}
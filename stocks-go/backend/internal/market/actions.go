@@ -0,0 +1,57 @@
+package market
+
+import (
+	"log"
+	"stocks-backend/internal/storage"
+	"stocks-backend/internal/websocket"
+	"time"
+)
+
+// ActionScheduler applies corporate actions as they fall due and announces
+// them to WebSocket clients as {"type": "corporateAction", "action": {...}}.
+// The open orders a split changes are only reported to their owners, on
+// their private account channels.
+type ActionScheduler struct {
+	storage storage.Store
+	hub     *websocket.Hub
+	ticker  *time.Ticker
+	done    chan struct{}
+}
+
+// NewActionScheduler creates a scheduler that checks for due actions every
+// interval
+func NewActionScheduler(store storage.Store, hub *websocket.Hub, interval time.Duration) *ActionScheduler {
+	return &ActionScheduler{
+		storage: store,
+		hub:     hub,
+		ticker:  time.NewTicker(interval),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start begins applying actions in the background
+func (a *ActionScheduler) Start() {
+	go func() {
+		for {
+			select {
+			case now := <-a.ticker.C:
+				for _, action := range a.storage.ApplyCorporateActions(now) {
+					if err := a.hub.Publish(websocket.ChannelCorporateActions, action.Symbol, map[string]interface{}{
+						"type":   "corporateAction",
+						"action": action,
+					}); err != nil {
+						log.Printf("Error broadcasting corporate action: %v", err)
+					}
+				}
+			case <-a.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the scheduler
+func (a *ActionScheduler) Stop() {
+	a.ticker.Stop()
+	close(a.done)
+}
@@ -0,0 +1,402 @@
+package market
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata" // so that calendars can name time zones on any host
+)
+
+// Session is a part of the trading day
+type Session string
+
+// Sessions of the trading day
+const (
+	SessionClosed     Session = "closed"
+	SessionPreMarket  Session = "pre"
+	SessionRegular    Session = "regular"
+	SessionAfterHours Session = "after"
+)
+
+// Rules say what happens during a session
+type Rules struct {
+	AcceptOrders bool     `json:"acceptOrders"`
+	OrderTypes   []string `json:"orderTypes,omitempty"` // order types accepted; empty accepts all
+	Activity     float64  `json:"activity"`             // share of the simulated market time per tick; 0 pauses prices
+}
+
+// Accepts reports whether an order of orderType may be placed under r
+func (r Rules) Accepts(orderType string) bool {
+	if !r.AcceptOrders {
+		return false
+	}
+	if len(r.OrderTypes) == 0 {
+		return true
+	}
+	for _, accepted := range r.OrderTypes {
+		if accepted == orderType {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRules are the rules of each session unless configured otherwise:
+// the regular session takes every order at full activity, the extended
+// sessions take limit orders only while prices move at a quarter of the
+// pace, and nothing trades while the market is closed
+var DefaultRules = map[Session]Rules{
+	SessionPreMarket:  {AcceptOrders: true, OrderTypes: []string{"limit"}, Activity: 0.25},
+	SessionRegular:    {AcceptOrders: true, Activity: 1},
+	SessionAfterHours: {AcceptOrders: true, OrderTypes: []string{"limit"}, Activity: 0.25},
+	SessionClosed:     {AcceptOrders: false, Activity: 0},
+}
+
+// Holiday is a day the market stays closed
+type Holiday struct {
+	Date string `json:"date"` // 2006-01-02
+	Name string `json:"name"`
+}
+
+// SessionConfig places a session in the trading day. Rules left out take
+// the session's DefaultRules.
+type SessionConfig struct {
+	Session      Session  `json:"session"` // "pre", "regular" or "after"
+	Open         string   `json:"open"`    // 15:04 local time
+	Close        string   `json:"close"`   // 15:04 local time; 24:00 for midnight
+	AcceptOrders *bool    `json:"acceptOrders,omitempty"`
+	OrderTypes   []string `json:"orderTypes,omitempty"`
+	Activity     *float64 `json:"activity,omitempty"`
+}
+
+// CalendarConfig describes an exchange calendar
+type CalendarConfig struct {
+	Timezone    string          `json:"timezone"`    // IANA zone such as America/New_York; default local
+	TradingDays []string        `json:"tradingDays"` // weekdays such as "Mon"; default Monday to Friday
+	Sessions    []SessionConfig `json:"sessions"`
+	Closed      *SessionConfig  `json:"closed,omitempty"` // rules outside sessions; times are ignored
+	Holidays    []Holiday       `json:"holidays"`
+}
+
+// Calendar tells which session the market is in at any time. Sessions are
+// set in local wall-clock time, so they follow daylight saving changes.
+type Calendar struct {
+	location    *time.Location
+	tradingDays map[time.Weekday]bool
+	sessions    []period // in order through the day
+	rules       map[Session]Rules
+	holidays    map[string]string // date -> name
+}
+
+// period is a session's hours as offsets from local midnight
+type period struct {
+	session     Session
+	open, close time.Duration
+}
+
+// weekdays maps the names a config may use to weekdays
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// NewCalendar checks config and builds the Calendar it describes
+func NewCalendar(config CalendarConfig) (*Calendar, error) {
+	c := &Calendar{
+		location:    time.Local,
+		tradingDays: make(map[time.Weekday]bool),
+		rules:       make(map[Session]Rules, len(DefaultRules)),
+		holidays:    make(map[string]string),
+	}
+	if config.Timezone != "" {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone %q", config.Timezone)
+		}
+		c.location = location
+	}
+
+	if len(config.TradingDays) == 0 {
+		config.TradingDays = []string{"Mon", "Tue", "Wed", "Thu", "Fri"}
+	}
+	for _, name := range config.TradingDays {
+		day, exists := weekdays[strings.ToLower(name)[:min(3, len(name))]]
+		if !exists {
+			return nil, fmt.Errorf("unknown trading day %q", name)
+		}
+		c.tradingDays[day] = true
+	}
+
+	for session, rules := range DefaultRules {
+		c.rules[session] = rules
+	}
+	if config.Closed != nil {
+		c.rules[SessionClosed] = config.Closed.rules(SessionClosed)
+	}
+	for _, session := range config.Sessions {
+		if _, known := DefaultRules[session.Session]; !known || session.Session == SessionClosed {
+			return nil, fmt.Errorf("unknown session %q: use pre, regular or after", session.Session)
+		}
+		open, err := parseClock(session.Open)
+		if err != nil {
+			return nil, fmt.Errorf("%s session: %w", session.Session, err)
+		}
+		close, err := parseClock(session.Close)
+		if err != nil {
+			return nil, fmt.Errorf("%s session: %w", session.Session, err)
+		}
+		if close <= open {
+			return nil, fmt.Errorf("%s session must close after it opens", session.Session)
+		}
+		if session.Activity != nil && *session.Activity < 0 {
+			return nil, fmt.Errorf("%s session activity cannot be negative", session.Session)
+		}
+		c.sessions = append(c.sessions, period{session: session.Session, open: open, close: close})
+		c.rules[session.Session] = session.rules(session.Session)
+	}
+	if len(c.sessions) == 0 {
+		return nil, fmt.Errorf("at least one session is needed")
+	}
+	sort.Slice(c.sessions, func(i, j int) bool { return c.sessions[i].open < c.sessions[j].open })
+	for i := 1; i < len(c.sessions); i++ {
+		if c.sessions[i].open < c.sessions[i-1].close {
+			return nil, fmt.Errorf("%s and %s sessions overlap", c.sessions[i-1].session, c.sessions[i].session)
+		}
+	}
+
+	for _, holiday := range config.Holidays {
+		if _, err := time.Parse("2006-01-02", holiday.Date); err != nil {
+			return nil, fmt.Errorf("holiday %q: dates must look like 2006-01-02", holiday.Name)
+		}
+		c.holidays[holiday.Date] = holiday.Name
+	}
+	return c, nil
+}
+
+// rules returns the rules of a configured session, filling in its defaults
+func (s SessionConfig) rules(session Session) Rules {
+	rules := DefaultRules[session]
+	if s.AcceptOrders != nil {
+		rules.AcceptOrders = *s.AcceptOrders
+	}
+	if s.OrderTypes != nil {
+		rules.OrderTypes = s.OrderTypes
+	}
+	if s.Activity != nil {
+		rules.Activity = *s.Activity
+	}
+	return rules
+}
+
+// parseClock reads a 15:04 time of day as an offset from midnight
+func parseClock(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: use 15:04", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// LoadCalendar reads a CalendarConfig from a JSON file
+func LoadCalendar(path string) (*Calendar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config CalendarConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	calendar, err := NewCalendar(config)
+	if err != nil {
+		return nil, fmt.Errorf("calendar in %s: %w", path, err)
+	}
+	return calendar, nil
+}
+
+// AlwaysOpen is a calendar with one regular session around the clock, every
+// day of the year
+func AlwaysOpen() *Calendar {
+	calendar, _ := NewCalendar(CalendarConfig{
+		TradingDays: []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+		Sessions:    []SessionConfig{{Session: SessionRegular, Open: "00:00", Close: "24:00"}},
+	})
+	return calendar
+}
+
+// USEquities is the calendar of the US stock exchanges: pre-market from
+// 4:00 to 9:30, the regular session until 16:00 and after-hours until 20:00
+// New York time on weekdays, closed on NYSE holidays
+func USEquities() *Calendar {
+	calendar, _ := NewCalendar(CalendarConfig{
+		Timezone: "America/New_York",
+		Sessions: []SessionConfig{
+			{Session: SessionPreMarket, Open: "04:00", Close: "09:30"},
+			{Session: SessionRegular, Open: "09:30", Close: "16:00"},
+			{Session: SessionAfterHours, Open: "16:00", Close: "20:00"},
+		},
+		Holidays: USHolidays,
+	})
+	return calendar
+}
+
+// USHolidays are the NYSE full-day closures of 2025 to 2027
+var USHolidays = []Holiday{
+	{Date: "2025-01-01", Name: "New Year's Day"},
+	{Date: "2025-01-20", Name: "Martin Luther King Jr. Day"},
+	{Date: "2025-02-17", Name: "Washington's Birthday"},
+	{Date: "2025-04-18", Name: "Good Friday"},
+	{Date: "2025-05-26", Name: "Memorial Day"},
+	{Date: "2025-06-19", Name: "Juneteenth"},
+	{Date: "2025-07-04", Name: "Independence Day"},
+	{Date: "2025-09-01", Name: "Labor Day"},
+	{Date: "2025-11-27", Name: "Thanksgiving Day"},
+	{Date: "2025-12-25", Name: "Christmas Day"},
+	{Date: "2026-01-01", Name: "New Year's Day"},
+	{Date: "2026-01-19", Name: "Martin Luther King Jr. Day"},
+	{Date: "2026-02-16", Name: "Washington's Birthday"},
+	{Date: "2026-04-03", Name: "Good Friday"},
+	{Date: "2026-05-25", Name: "Memorial Day"},
+	{Date: "2026-06-19", Name: "Juneteenth"},
+	{Date: "2026-07-03", Name: "Independence Day"},
+	{Date: "2026-09-07", Name: "Labor Day"},
+	{Date: "2026-11-26", Name: "Thanksgiving Day"},
+	{Date: "2026-12-25", Name: "Christmas Day"},
+	{Date: "2027-01-01", Name: "New Year's Day"},
+	{Date: "2027-01-18", Name: "Martin Luther King Jr. Day"},
+	{Date: "2027-02-15", Name: "Washington's Birthday"},
+	{Date: "2027-03-26", Name: "Good Friday"},
+	{Date: "2027-05-31", Name: "Memorial Day"},
+	{Date: "2027-06-18", Name: "Juneteenth"},
+	{Date: "2027-07-05", Name: "Independence Day"},
+	{Date: "2027-09-06", Name: "Labor Day"},
+	{Date: "2027-11-25", Name: "Thanksgiving Day"},
+	{Date: "2027-12-24", Name: "Christmas Day"},
+}
+
+// holiday returns the name of the holiday on the local date of t, if any
+func (c *Calendar) holiday(t time.Time) (string, bool) {
+	name, exists := c.holidays[t.In(c.location).Format("2006-01-02")]
+	return name, exists
+}
+
+// isTradingDay reports whether the local date of t has sessions
+func (c *Calendar) isTradingDay(t time.Time) bool {
+	local := t.In(c.location)
+	if _, closed := c.holiday(local); closed {
+		return false
+	}
+	return c.tradingDays[local.Weekday()]
+}
+
+// clock returns the local wall-clock time of t as an offset from midnight
+func (c *Calendar) clock(t time.Time) time.Duration {
+	local := t.In(c.location)
+	return time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second + time.Duration(local.Nanosecond())
+}
+
+// at returns the time offset from local midnight on the local date of t
+func (c *Calendar) at(t time.Time, offset time.Duration) time.Time {
+	year, month, day := t.In(c.location).Date()
+	return time.Date(year, month, day, 0, 0, 0, int(offset), c.location)
+}
+
+// SessionAt returns the session the market is in at t
+func (c *Calendar) SessionAt(t time.Time) Session {
+	if !c.isTradingDay(t) {
+		return SessionClosed
+	}
+	clock := c.clock(t)
+	for _, p := range c.sessions {
+		if clock >= p.open && clock < p.close {
+			return p.session
+		}
+	}
+	return SessionClosed
+}
+
+// Rules returns the rules of the session the market is in at t
+func (c *Calendar) Rules(t time.Time) Rules {
+	return c.rules[c.SessionAt(t)]
+}
+
+// calendarHorizon is how far ahead NextChange looks for a session change
+const calendarHorizon = 31
+
+// NextChange returns the first time after t at which the session changes,
+// and the session it changes to. It returns the zero time if the session
+// does not change within a month, as for a market that never closes.
+func (c *Calendar) NextChange(t time.Time) (time.Time, Session) {
+	current := c.SessionAt(t)
+	for day := 0; day <= calendarHorizon; day++ {
+		date := c.at(t, 0).AddDate(0, 0, day)
+		candidates := make([]time.Time, 0, 2*len(c.sessions)+1)
+		if c.isTradingDay(date) {
+			for _, p := range c.sessions {
+				candidates = append(candidates, c.at(date, p.open), c.at(date, p.close))
+			}
+		}
+		candidates = append(candidates, c.at(date.AddDate(0, 0, 1), 0))
+
+		for _, candidate := range candidates {
+			if !candidate.After(t) {
+				continue
+			}
+			if session := c.SessionAt(candidate); session != current {
+				return candidate, session
+			}
+		}
+	}
+	return time.Time{}, current
+}
+
+// EndOfDay returns when the trading day that t falls in ends: the close of
+// the last session of t's date, or if that has passed or t's date has no
+// sessions, of the next trading day. DAY orders expire then.
+func (c *Calendar) EndOfDay(t time.Time) time.Time {
+	last := c.sessions[len(c.sessions)-1].close
+	for day := 0; day <= calendarHorizon; day++ {
+		date := c.at(t, 0).AddDate(0, 0, day)
+		if end := c.at(date, last); c.isTradingDay(date) && end.After(t) {
+			return end
+		}
+	}
+	return c.at(t, 24*time.Hour)
+}
+
+// Status describes the market at a moment
+type Status struct {
+	Time        time.Time  `json:"time"`
+	Timezone    string     `json:"timezone"`
+	Session     Session    `json:"session"`
+	Rules       Rules      `json:"rules"`
+	Holiday     string     `json:"holiday,omitempty"`
+	NextSession Session    `json:"nextSession,omitempty"`
+	NextChange  *time.Time `json:"nextChange,omitempty"` // omitted for a market that never closes
+}
+
+// Status returns the market's status at t
+func (c *Calendar) Status(t time.Time) Status {
+	session := c.SessionAt(t)
+	status := Status{
+		Time:     t,
+		Timezone: c.location.String(),
+		Session:  session,
+		Rules:    c.rules[session],
+	}
+	if name, exists := c.holiday(t); exists {
+		status.Holiday = name
+	}
+	if next, session := c.NextChange(t); !next.IsZero() {
+		status.NextSession = session
+		status.NextChange = &next
+	}
+	return status
+}
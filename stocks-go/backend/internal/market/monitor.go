@@ -0,0 +1,59 @@
+package market
+
+import (
+	"log"
+	"stocks-backend/internal/websocket"
+	"time"
+)
+
+// Monitor watches the calendar and announces each session change to
+// WebSocket clients as {"type": "marketStatus", "status": {...}}
+type Monitor struct {
+	calendar *Calendar
+	hub      *websocket.Hub
+	ticker   *time.Ticker
+	done     chan struct{}
+}
+
+// NewMonitor creates a monitor that checks the session every interval
+func NewMonitor(calendar *Calendar, hub *websocket.Hub, interval time.Duration) *Monitor {
+	return &Monitor{
+		calendar: calendar,
+		hub:      hub,
+		ticker:   time.NewTicker(interval),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start begins watching in the background
+func (m *Monitor) Start() {
+	go func() {
+		session := m.calendar.SessionAt(time.Now())
+		log.Printf("Market session: %s", session)
+		for {
+			select {
+			case now := <-m.ticker.C:
+				status := m.calendar.Status(now)
+				if status.Session == session {
+					continue
+				}
+				log.Printf("Market session changed from %s to %s", session, status.Session)
+				session = status.Session
+				if err := m.hub.Publish(websocket.ChannelMarket, "", map[string]interface{}{
+					"type":   "marketStatus",
+					"status": status,
+				}); err != nil {
+					log.Printf("Error broadcasting market status: %v", err)
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the monitor
+func (m *Monitor) Stop() {
+	m.ticker.Stop()
+	close(m.done)
+}
@@ -0,0 +1,291 @@
+package matching
+
+import (
+	"sort"
+	"stocks-backend/internal/decimal"
+	"sync"
+)
+
+// Entry is a resting order on one side of the book
+type Entry struct {
+	OrderID   string          `json:"orderId"`
+	Username  string          `json:"username"`
+	Side      string          `json:"side"` // "buy" or "sell"
+	Price     decimal.Decimal `json:"price"`
+	Remaining int             `json:"remaining"`
+
+	seq uint64 // arrival order, which Restore keeps
+}
+
+// Fill represents a single execution between a taker and a resting maker
+type Fill struct {
+	MakerOrderID  string          `json:"makerOrderId"`
+	MakerUsername string          `json:"makerUsername"`
+	Price         decimal.Decimal `json:"price"`
+	Quantity      int             `json:"quantity"`
+}
+
+// Level is an aggregated price level used for depth snapshots
+type Level struct {
+	Price    decimal.Decimal `json:"price"`
+	Quantity int             `json:"quantity"`
+	Orders   int             `json:"orders"`
+}
+
+// SettleFunc is called for every prospective fill and performs the transfer
+// between the two accounts. It returns the quantity (up to qty) that was
+// actually settled; returning 0 skips the maker and leaves it resting.
+type SettleFunc func(maker *Entry, qty int, price decimal.Decimal) int
+
+// OrderBook is a price-time priority limit order book for a single symbol.
+// Bids are kept highest price first and asks lowest price first; within a
+// price level the oldest order is always filled first.
+type OrderBook struct {
+	Symbol string
+	bids   []*Entry
+	asks   []*Entry
+	index  map[string]*Entry
+	seq    uint64 // last arrival order given out
+	mutex  sync.Mutex
+}
+
+// NewOrderBook creates an empty order book for a symbol
+func NewOrderBook(symbol string) *OrderBook {
+	return &OrderBook{
+		Symbol: symbol,
+		bids:   make([]*Entry, 0),
+		asks:   make([]*Entry, 0),
+		index:  make(map[string]*Entry),
+	}
+}
+
+// Add rests an order on the book behind all orders at the same price
+func (b *OrderBook) Add(orderID, username, side string, price decimal.Decimal, quantity int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.seq++
+	b.place(&Entry{
+		OrderID:   orderID,
+		Username:  username,
+		Side:      side,
+		Price:     price,
+		Remaining: quantity,
+		seq:       b.seq,
+	})
+}
+
+// Restore puts an entry taken off the book with Remove back in the place
+// it had, ahead of the orders at its price that arrived after it
+func (b *OrderBook) Restore(entry Entry) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.place(&entry)
+}
+
+// place rests entry behind the orders at its price that arrived before it.
+// Callers must hold mutex.
+func (b *OrderBook) place(entry *Entry) {
+	b.index[entry.OrderID] = entry
+	if entry.Side == "buy" {
+		b.bids = insert(b.bids, entry, func(a, e *Entry) bool { return a.Price < e.Price || (a.Price == e.Price && a.seq > e.seq) })
+	} else {
+		b.asks = insert(b.asks, entry, func(a, e *Entry) bool { return a.Price > e.Price || (a.Price == e.Price && a.seq > e.seq) })
+	}
+}
+
+// insert places entry before the first existing order it outranks on price
+// or, at the same price, on arrival order
+func insert(side []*Entry, entry *Entry, worse func(existing, entry *Entry) bool) []*Entry {
+	i := sort.Search(len(side), func(i int) bool { return worse(side[i], entry) })
+	side = append(side, nil)
+	copy(side[i+1:], side[i:])
+	side[i] = entry
+	return side
+}
+
+// Remove takes an order off the book, returning its last resting state
+func (b *OrderBook) Remove(orderID string) (Entry, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	entry, exists := b.index[orderID]
+	if !exists {
+		return Entry{}, false
+	}
+	b.removeEntry(entry)
+	return *entry, true
+}
+
+func (b *OrderBook) removeEntry(entry *Entry) {
+	delete(b.index, entry.OrderID)
+	if entry.Side == "buy" {
+		b.bids = without(b.bids, entry)
+	} else {
+		b.asks = without(b.asks, entry)
+	}
+}
+
+func without(side []*Entry, entry *Entry) []*Entry {
+	for i, e := range side {
+		if e == entry {
+			return append(side[:i], side[i+1:]...)
+		}
+	}
+	return side
+}
+
+// Get returns a copy of a resting order
+func (b *OrderBook) Get(orderID string) (Entry, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	entry, exists := b.index[orderID]
+	if !exists {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+// Match sweeps the opposite side of the book for an incoming order.
+// Makers are filled in price-time priority while their price is at or
+// better than limit, and settle is invoked for each of them. Orders from
+// the taker's own account are skipped so a user can never trade with
+// themselves. Filled makers are removed from the book; the returned fills
+// are in execution order.
+func (b *OrderBook) Match(taker, side string, quantity int, limit decimal.Decimal, settle SettleFunc) []Fill {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	contra := b.asks
+	crosses := func(price decimal.Decimal) bool { return price <= limit }
+	if side == "sell" {
+		contra = b.bids
+		crosses = func(price decimal.Decimal) bool { return price >= limit }
+	}
+
+	fills := make([]Fill, 0)
+	done := make([]*Entry, 0)
+	for _, maker := range contra {
+		if quantity == 0 || !crosses(maker.Price) {
+			break
+		}
+		if maker.Username == taker {
+			continue
+		}
+
+		qty := quantity
+		if maker.Remaining < qty {
+			qty = maker.Remaining
+		}
+		qty = settle(maker, qty, maker.Price)
+		if qty <= 0 {
+			continue
+		}
+
+		maker.Remaining -= qty
+		quantity -= qty
+		fills = append(fills, Fill{
+			MakerOrderID:  maker.OrderID,
+			MakerUsername: maker.Username,
+			Price:         maker.Price,
+			Quantity:      qty,
+		})
+		if maker.Remaining == 0 {
+			done = append(done, maker)
+		}
+	}
+
+	for _, maker := range done {
+		b.removeEntry(maker)
+	}
+	return fills
+}
+
+// Available returns how much of the opposite side could trade with an
+// incoming order from taker at limit, ignoring the taker's own orders
+func (b *OrderBook) Available(taker, side string, limit decimal.Decimal) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	contra := b.asks
+	crosses := func(price decimal.Decimal) bool { return price <= limit }
+	if side == "sell" {
+		contra = b.bids
+		crosses = func(price decimal.Decimal) bool { return price >= limit }
+	}
+
+	total := 0
+	for _, e := range contra {
+		if !crosses(e.Price) {
+			break
+		}
+		if e.Username != taker {
+			total += e.Remaining
+		}
+	}
+	return total
+}
+
+// Crossed returns the resting orders that would trade against an external
+// quote at price, in price-time priority. Bids at or above the price and
+// asks at or below it are returned; the book itself is left untouched.
+func (b *OrderBook) Crossed(price decimal.Decimal) []Entry {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	crossed := make([]Entry, 0)
+	for _, e := range b.bids {
+		if e.Price < price {
+			break
+		}
+		crossed = append(crossed, *e)
+	}
+	for _, e := range b.asks {
+		if e.Price > price {
+			break
+		}
+		crossed = append(crossed, *e)
+	}
+	return crossed
+}
+
+// Reduce lowers the resting quantity of an order after an execution that
+// happened away from the book, removing it once nothing is left
+func (b *OrderBook) Reduce(orderID string, quantity int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	entry, exists := b.index[orderID]
+	if !exists {
+		return
+	}
+	entry.Remaining -= quantity
+	if entry.Remaining <= 0 {
+		b.removeEntry(entry)
+	}
+}
+
+// Depth returns aggregated price levels for both sides of the book
+func (b *OrderBook) Depth(levels int) (bids, asks []Level) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return aggregate(b.bids, levels), aggregate(b.asks, levels)
+}
+
+func aggregate(side []*Entry, levels int) []Level {
+	result := make([]Level, 0)
+	for _, e := range side {
+		n := len(result)
+		if n > 0 && result[n-1].Price == e.Price {
+			result[n-1].Quantity += e.Remaining
+			result[n-1].Orders++
+			continue
+		}
+		if levels > 0 && n == levels {
+			break
+		}
+		result = append(result, Level{Price: e.Price, Quantity: e.Remaining, Orders: 1})
+	}
+	return result
+}
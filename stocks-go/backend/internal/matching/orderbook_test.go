@@ -0,0 +1,321 @@
+package matching
+
+import (
+	"reflect"
+	"stocks-backend/internal/decimal"
+	"testing"
+)
+
+// resting is an order to put on a book before a test
+type resting struct {
+	id, username, side string
+	price              string
+	quantity           int
+}
+
+func price(s string) decimal.Decimal {
+	d, err := decimal.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func newBook(orders []resting) *OrderBook {
+	book := NewOrderBook("TEST")
+	for _, o := range orders {
+		book.Add(o.id, o.username, o.side, price(o.price), o.quantity)
+	}
+	return book
+}
+
+// queue returns the IDs of a side of the book in priority order
+func queue(side []*Entry) []string {
+	ids := make([]string, 0, len(side))
+	for _, e := range side {
+		ids = append(ids, e.OrderID)
+	}
+	return ids
+}
+
+func TestAddKeepsPriceTimePriority(t *testing.T) {
+	tests := []struct {
+		name       string
+		orders     []resting
+		bids, asks []string
+	}{
+		{
+			name:   "bids highest first",
+			orders: []resting{{"b1", "u", "buy", "10", 1}, {"b2", "u", "buy", "11", 1}, {"b3", "u", "buy", "9.5", 1}},
+			bids:   []string{"b2", "b1", "b3"},
+			asks:   []string{},
+		},
+		{
+			name:   "asks lowest first",
+			orders: []resting{{"a1", "u", "sell", "10", 1}, {"a2", "u", "sell", "9", 1}, {"a3", "u", "sell", "12", 1}},
+			bids:   []string{},
+			asks:   []string{"a2", "a1", "a3"},
+		},
+		{
+			name: "oldest first within a level",
+			orders: []resting{
+				{"b1", "u", "buy", "10", 1}, {"b2", "v", "buy", "10", 1}, {"b3", "u", "buy", "10.01", 1}, {"b4", "w", "buy", "10", 1},
+				{"a1", "u", "sell", "11", 1}, {"a2", "v", "sell", "11", 1},
+			},
+			bids: []string{"b3", "b1", "b2", "b4"},
+			asks: []string{"a1", "a2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newBook(tt.orders)
+			if got := queue(book.bids); !reflect.DeepEqual(got, tt.bids) {
+				t.Errorf("bids = %v, want %v", got, tt.bids)
+			}
+			if got := queue(book.asks); !reflect.DeepEqual(got, tt.asks) {
+				t.Errorf("asks = %v, want %v", got, tt.asks)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	asks := []resting{
+		{"a1", "alice", "sell", "10", 5},
+		{"a2", "bob", "sell", "10", 5},
+		{"a3", "carol", "sell", "10.5", 10},
+		{"a4", "alice", "sell", "11", 10},
+	}
+	bids := []resting{
+		{"b1", "alice", "buy", "9", 5},
+		{"b2", "bob", "buy", "9.5", 5},
+	}
+
+	tests := []struct {
+		name     string
+		orders   []resting
+		taker    string
+		side     string
+		quantity int
+		limit    string
+		settle   func(maker *Entry, qty int) int // nil settles everything
+		fills    []Fill
+		left     []string // the contra side afterwards
+	}{
+		{
+			name: "fills oldest first at a level", orders: asks,
+			taker: "dave", side: "buy", quantity: 7, limit: "10",
+			fills: []Fill{{"a1", "alice", price("10"), 5}, {"a2", "bob", price("10"), 2}},
+			left:  []string{"a2", "a3", "a4"},
+		},
+		{
+			name: "sweeps levels up to the limit", orders: asks,
+			taker: "dave", side: "buy", quantity: 100, limit: "10.5",
+			fills: []Fill{{"a1", "alice", price("10"), 5}, {"a2", "bob", price("10"), 5}, {"a3", "carol", price("10.5"), 10}},
+			left:  []string{"a4"},
+		},
+		{
+			name: "nothing crosses", orders: asks,
+			taker: "dave", side: "buy", quantity: 5, limit: "9.99",
+			fills: []Fill{},
+			left:  []string{"a1", "a2", "a3", "a4"},
+		},
+		{
+			name: "skips the taker's own orders", orders: asks,
+			taker: "alice", side: "buy", quantity: 12, limit: "11",
+			fills: []Fill{{"a2", "bob", price("10"), 5}, {"a3", "carol", price("10.5"), 7}},
+			left:  []string{"a1", "a3", "a4"},
+		},
+		{
+			name: "sells sweep bids highest first", orders: bids,
+			taker: "dave", side: "sell", quantity: 7, limit: "9",
+			fills: []Fill{{"b2", "bob", price("9.5"), 5}, {"b1", "alice", price("9"), 2}},
+			left:  []string{"b1"},
+		},
+		{
+			name: "makers that cannot settle are skipped", orders: asks,
+			taker: "dave", side: "buy", quantity: 6, limit: "10.5",
+			settle: func(maker *Entry, qty int) int {
+				if maker.Username == "alice" {
+					return 0
+				}
+				return qty
+			},
+			fills: []Fill{{"a2", "bob", price("10"), 5}, {"a3", "carol", price("10.5"), 1}},
+			left:  []string{"a1", "a3", "a4"},
+		},
+		{
+			name: "partial settlement leaves the rest resting", orders: asks,
+			taker: "dave", side: "buy", quantity: 5, limit: "10",
+			settle: func(maker *Entry, qty int) int { return min(qty, 3) },
+			fills:  []Fill{{"a1", "alice", price("10"), 3}, {"a2", "bob", price("10"), 2}},
+			left:   []string{"a1", "a2", "a3", "a4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newBook(tt.orders)
+			fills := book.Match(tt.taker, tt.side, tt.quantity, price(tt.limit), func(maker *Entry, qty int, _ decimal.Decimal) int {
+				if tt.settle == nil {
+					return qty
+				}
+				return tt.settle(maker, qty)
+			})
+			if !reflect.DeepEqual(fills, tt.fills) {
+				t.Errorf("fills = %+v, want %+v", fills, tt.fills)
+			}
+
+			contra := book.asks
+			if tt.side == "sell" {
+				contra = book.bids
+			}
+			if got := queue(contra); !reflect.DeepEqual(got, tt.left) {
+				t.Errorf("left = %v, want %v", got, tt.left)
+			}
+			for _, id := range tt.left {
+				if _, resting := book.Get(id); !resting {
+					t.Errorf("%s is not indexed", id)
+				}
+			}
+		})
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	book := newBook([]resting{
+		{"a1", "alice", "sell", "10", 5},
+		{"a2", "bob", "sell", "10.5", 5},
+		{"a3", "carol", "sell", "11", 5},
+	})
+
+	tests := []struct {
+		taker string
+		limit string
+		want  int
+	}{
+		{"dave", "9", 0},
+		{"dave", "10", 5},
+		{"dave", "10.5", 10},
+		{"alice", "11", 10},
+	}
+	for _, tt := range tests {
+		if got := book.Available(tt.taker, "buy", price(tt.limit)); got != tt.want {
+			t.Errorf("Available(%s, buy, %s) = %d, want %d", tt.taker, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestCrossed(t *testing.T) {
+	book := newBook([]resting{
+		{"b1", "u", "buy", "10", 1}, {"b2", "u", "buy", "9", 1},
+		{"a1", "u", "sell", "11", 1}, {"a2", "u", "sell", "12", 1},
+	})
+
+	tests := []struct {
+		price string
+		want  []string
+	}{
+		{"10.5", []string{}},
+		{"10", []string{"b1"}},
+		{"8", []string{"b1", "b2"}},
+		{"11", []string{"a1"}},
+		{"13", []string{"a1", "a2"}},
+	}
+	for _, tt := range tests {
+		ids := make([]string, 0)
+		for _, e := range book.Crossed(price(tt.price)) {
+			ids = append(ids, e.OrderID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("Crossed(%s) = %v, want %v", tt.price, ids, tt.want)
+		}
+	}
+}
+
+func TestRemoveAndRestore(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove string
+		add    []resting // added while the order is off the book
+		want   []string
+	}{
+		{name: "front of its level", remove: "b1", want: []string{"b1", "b2", "b3"}},
+		{name: "middle of its level", remove: "b2", want: []string{"b1", "b2", "b3"}},
+		{name: "ahead of later arrivals", remove: "b1", add: []resting{{"b4", "u", "buy", "10", 1}}, want: []string{"b1", "b2", "b3", "b4"}},
+		{name: "behind better prices", remove: "b3", add: []resting{{"b4", "u", "buy", "11", 1}}, want: []string{"b4", "b1", "b2", "b3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newBook([]resting{{"b1", "u", "buy", "10", 1}, {"b2", "v", "buy", "10", 2}, {"b3", "w", "buy", "10", 3}})
+			entry, ok := book.Remove(tt.remove)
+			if !ok {
+				t.Fatalf("Remove(%s) found nothing", tt.remove)
+			}
+			if _, ok := book.Remove(tt.remove); ok {
+				t.Fatalf("Remove(%s) removed it twice", tt.remove)
+			}
+			for _, o := range tt.add {
+				book.Add(o.id, o.username, o.side, price(o.price), o.quantity)
+			}
+			book.Restore(entry)
+			if got := queue(book.bids); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bids = %v, want %v", got, tt.want)
+			}
+			if got, _ := book.Get(tt.remove); got.Remaining != entry.Remaining {
+				t.Errorf("restored remaining = %d, want %d", got.Remaining, entry.Remaining)
+			}
+		})
+	}
+}
+
+func TestReduce(t *testing.T) {
+	tests := []struct {
+		reduce  int
+		resting bool
+		left    int
+	}{
+		{reduce: 2, resting: true, left: 3},
+		{reduce: 5, resting: false},
+		{reduce: 7, resting: false},
+	}
+	for _, tt := range tests {
+		book := newBook([]resting{{"a1", "u", "sell", "10", 5}})
+		book.Reduce("a1", tt.reduce)
+		entry, resting := book.Get("a1")
+		if resting != tt.resting || entry.Remaining != tt.left {
+			t.Errorf("Reduce(%d): resting %v with %d, want %v with %d", tt.reduce, resting, entry.Remaining, tt.resting, tt.left)
+		}
+	}
+}
+
+func TestDepth(t *testing.T) {
+	book := newBook([]resting{
+		{"b1", "u", "buy", "10", 1}, {"b2", "v", "buy", "10", 2}, {"b3", "u", "buy", "9", 4},
+		{"a1", "u", "sell", "11", 3}, {"a2", "u", "sell", "12", 1}, {"a3", "v", "sell", "13", 1},
+	})
+
+	tests := []struct {
+		levels     int
+		bids, asks []Level
+	}{
+		{
+			levels: 0,
+			bids:   []Level{{price("10"), 3, 2}, {price("9"), 4, 1}},
+			asks:   []Level{{price("11"), 3, 1}, {price("12"), 1, 1}, {price("13"), 1, 1}},
+		},
+		{
+			levels: 1,
+			bids:   []Level{{price("10"), 3, 2}},
+			asks:   []Level{{price("11"), 3, 1}},
+		},
+	}
+	for _, tt := range tests {
+		bids, asks := book.Depth(tt.levels)
+		if !reflect.DeepEqual(bids, tt.bids) || !reflect.DeepEqual(asks, tt.asks) {
+			t.Errorf("Depth(%d) = %v / %v, want %v / %v", tt.levels, bids, asks, tt.bids, tt.asks)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// CorrelationMatrix correlates the shocks of a set of symbols directly,
+// in place of their factor loadings. Matrix[i][j] is the correlation of
+// Symbols[i] with Symbols[j].
+type CorrelationMatrix struct {
+	Symbols []string    `json:"symbols"`
+	Matrix  [][]float64 `json:"matrix"`
+
+	lower [][]float64 // Cholesky factor of Matrix
+}
+
+// NewCorrelationMatrix checks that matrix is a valid correlation matrix for
+// symbols, symmetric and positive definite with ones on the diagonal, and
+// decomposes it for drawing shocks
+func NewCorrelationMatrix(symbols []string, matrix [][]float64) (*CorrelationMatrix, error) {
+	n := len(symbols)
+	if len(matrix) != n {
+		return nil, fmt.Errorf("matrix has %d rows for %d symbols", len(matrix), n)
+	}
+	seen := make(map[string]bool, n)
+	for i, symbol := range symbols {
+		if seen[symbol] {
+			return nil, fmt.Errorf("%s is listed twice", symbol)
+		}
+		seen[symbol] = true
+		if len(matrix[i]) != n {
+			return nil, fmt.Errorf("row %d has %d columns for %d symbols", i+1, len(matrix[i]), n)
+		}
+		if matrix[i][i] != 1 {
+			return nil, fmt.Errorf("correlation of %s with itself must be 1", symbol)
+		}
+		for j := 0; j < i; j++ {
+			if matrix[i][j] != matrix[j][i] {
+				return nil, fmt.Errorf("matrix is not symmetric for %s and %s", symbols[j], symbol)
+			}
+			if math.Abs(matrix[i][j]) > 1 {
+				return nil, fmt.Errorf("correlation of %s and %s must be between -1 and 1", symbols[j], symbol)
+			}
+		}
+	}
+
+	lower, err := cholesky(matrix)
+	if err != nil {
+		return nil, err
+	}
+	return &CorrelationMatrix{Symbols: symbols, Matrix: matrix, lower: lower}, nil
+}
+
+// LoadCorrelation reads a CorrelationMatrix from a JSON file of the form
+// {"symbols": [...], "matrix": [[...], ...]}
+func LoadCorrelation(path string) (*CorrelationMatrix, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var loaded CorrelationMatrix
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	matrix, err := NewCorrelationMatrix(loaded.Symbols, loaded.Matrix)
+	if err != nil {
+		return nil, fmt.Errorf("correlation matrix in %s: %w", path, err)
+	}
+	return matrix, nil
+}
+
+// cholesky returns the lower triangular L with L×Lᵀ = matrix
+func cholesky(matrix [][]float64) ([][]float64, error) {
+	n := len(matrix)
+	lower := make([][]float64, n)
+	for i := range lower {
+		lower[i] = make([]float64, i+1)
+		for j := 0; j <= i; j++ {
+			sum := matrix[i][j]
+			for k := 0; k < j; k++ {
+				sum -= lower[i][k] * lower[j][k]
+			}
+			if i == j {
+				if sum <= 0 {
+					return nil, fmt.Errorf("matrix is not positive definite")
+				}
+				lower[i][i] = math.Sqrt(sum)
+			} else {
+				lower[i][j] = sum / lower[j][j]
+			}
+		}
+	}
+	return lower, nil
+}
+
+// draw returns correlated standard normal shocks for the matrix's symbols
+func (c *CorrelationMatrix) draw(rng *rand.Rand) map[string]float64 {
+	independent := make([]float64, len(c.Symbols))
+	for i := range independent {
+		independent[i] = rng.NormFloat64()
+	}
+	shocks := make(map[string]float64, len(c.Symbols))
+	for i, row := range c.lower {
+		shock := 0.0
+		for j, weight := range row {
+			shock += weight * independent[j]
+		}
+		shocks[c.Symbols[i]] = shock
+	}
+	return shocks
+}
+
+// drawShocks returns a standard normal shock for each of symbols. Symbols in
+// matrix are correlated by it. The others follow a factor model: a symbol
+// with market loading m and sector loading s gets
+//
+//	m×market + s×sector + √(1−m²−s²)×own
+//
+// from independent standard normal draws for the market, each sector and
+// the symbol itself, so two symbols are correlated by m₁m₂, plus s₁s₂ if
+// they share a sector. Draws are made in a fixed order so that a seed
+// always gives the same shocks.
+func drawShocks(symbols []string, configs func(symbol string) ModelConfig, matrix *CorrelationMatrix, rng *rand.Rand) map[string]float64 {
+	shocks := make(map[string]float64, len(symbols))
+	if matrix != nil {
+		shocks = matrix.draw(rng)
+	}
+
+	market := rng.NormFloat64()
+	sectors := make(map[string]float64)
+	names := make([]string, 0)
+	for _, symbol := range symbols {
+		if sector := configs(symbol).Sector; sector != "" {
+			if _, exists := sectors[sector]; !exists {
+				sectors[sector] = 0
+				names = append(names, sector)
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sectors[name] = rng.NormFloat64()
+	}
+
+	for _, symbol := range symbols {
+		own := rng.NormFloat64()
+		if _, correlated := shocks[symbol]; correlated {
+			continue
+		}
+		config := configs(symbol)
+		m, s := config.MarketFactor, config.SectorFactor
+		shocks[symbol] = m*market + s*sectors[config.Sector] + math.Sqrt(math.Max(0, 1-m*m-s*s))*own
+	}
+	return shocks
+}
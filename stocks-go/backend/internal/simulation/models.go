@@ -0,0 +1,196 @@
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// TradingYear is the market time in a year of trading days, which model
+// parameters are annualized over
+const TradingYear = 252 * 6.5 * float64(time.Hour)
+
+// PriceModel is a stochastic process that moves a price forward in time.
+// Next returns the price dt years after price, given shock, a standard
+// normal draw for the diffusion that may be correlated with other symbols'.
+// Any further randomness, such as jumps, is drawn from rng.
+type PriceModel interface {
+	Next(price, dt, shock float64, rng *rand.Rand) float64
+}
+
+// GBM is geometric Brownian motion: log returns are normal with the given
+// annual drift and volatility
+type GBM struct {
+	Drift      float64
+	Volatility float64
+}
+
+// Next implements PriceModel
+func (m GBM) Next(price, dt, shock float64, rng *rand.Rand) float64 {
+	return price * math.Exp((m.Drift-m.Volatility*m.Volatility/2)*dt+m.Volatility*math.Sqrt(dt)*shock)
+}
+
+// OrnsteinUhlenbeck is a mean-reverting process: the price is pulled toward
+// Mean at rate Reversion per year, with Volatility in price units
+type OrnsteinUhlenbeck struct {
+	Mean       float64
+	Reversion  float64
+	Volatility float64
+}
+
+// Next implements PriceModel using the exact transition of the process
+func (m OrnsteinUhlenbeck) Next(price, dt, shock float64, rng *rand.Rand) float64 {
+	if m.Reversion <= 0 {
+		return price + m.Volatility*math.Sqrt(dt)*shock
+	}
+	decay := math.Exp(-m.Reversion * dt)
+	spread := m.Volatility * math.Sqrt((1-decay*decay)/(2*m.Reversion))
+	return m.Mean + (price-m.Mean)*decay + spread*shock
+}
+
+// JumpDiffusion is Merton's jump-diffusion: geometric Brownian motion plus
+// jumps arriving JumpIntensity times a year on average, whose log sizes are
+// normal with mean JumpMean and standard deviation JumpVolatility. The drift
+// is compensated so that jumps do not change the expected return.
+type JumpDiffusion struct {
+	GBM
+	JumpIntensity  float64
+	JumpMean       float64
+	JumpVolatility float64
+}
+
+// Next implements PriceModel
+func (m JumpDiffusion) Next(price, dt, shock float64, rng *rand.Rand) float64 {
+	compensation := m.JumpIntensity * (math.Exp(m.JumpMean+m.JumpVolatility*m.JumpVolatility/2) - 1)
+	diffusion := GBM{Drift: m.Drift - compensation, Volatility: m.Volatility}
+	next := diffusion.Next(price, dt, shock, rng)
+
+	for jumps := poisson(m.JumpIntensity*dt, rng); jumps > 0; jumps-- {
+		next *= math.Exp(m.JumpMean + m.JumpVolatility*rng.NormFloat64())
+	}
+	return next
+}
+
+// poisson draws from a Poisson distribution with the given mean, which is
+// small for a single tick
+func poisson(mean float64, rng *rand.Rand) int {
+	if mean <= 0 {
+		return 0
+	}
+	limit, product, n := math.Exp(-mean), rng.Float64(), 0
+	for product > limit {
+		product *= rng.Float64()
+		n++
+	}
+	return n
+}
+
+// ModelConfig selects and parameterizes a symbol's price model. Rates and
+// volatilities are annual. The factor loadings correlate the symbol's shocks
+// with the market and its sector; see Simulator.
+type ModelConfig struct {
+	Model      string  `json:"model"` // "gbm", "ou" or "jump"
+	Drift      float64 `json:"drift,omitempty"`
+	Volatility float64 `json:"volatility"`
+
+	// Ornstein-Uhlenbeck only; Volatility is then in price units
+	Mean      float64 `json:"mean,omitempty"`
+	Reversion float64 `json:"reversion,omitempty"`
+
+	// Jump-diffusion only
+	JumpIntensity  float64 `json:"jumpIntensity,omitempty"`
+	JumpMean       float64 `json:"jumpMean,omitempty"`
+	JumpVolatility float64 `json:"jumpVolatility,omitempty"`
+
+	// Factor model: loadings on the market factor and the sector factor,
+	// whose squares add up to at most 1
+	Sector       string  `json:"sector,omitempty"`
+	MarketFactor float64 `json:"marketFactor,omitempty"`
+	SectorFactor float64 `json:"sectorFactor,omitempty"`
+}
+
+// NewModel builds the PriceModel described by config
+func NewModel(config ModelConfig) (PriceModel, error) {
+	if config.Volatility < 0 || config.Reversion < 0 || config.JumpIntensity < 0 || config.JumpVolatility < 0 {
+		return nil, fmt.Errorf("volatilities, reversion and jump intensity cannot be negative")
+	}
+	if config.MarketFactor*config.MarketFactor+config.SectorFactor*config.SectorFactor > 1 {
+		return nil, fmt.Errorf("the squares of marketFactor and sectorFactor cannot add up to more than 1")
+	}
+	if config.SectorFactor != 0 && config.Sector == "" {
+		return nil, fmt.Errorf("sectorFactor needs a sector")
+	}
+	gbm := GBM{Drift: config.Drift, Volatility: config.Volatility}
+	switch config.Model {
+	case "", "gbm":
+		return gbm, nil
+	case "ou":
+		if config.Mean <= 0 {
+			return nil, fmt.Errorf("ou model needs a mean above 0")
+		}
+		return OrnsteinUhlenbeck{Mean: config.Mean, Reversion: config.Reversion, Volatility: config.Volatility}, nil
+	case "jump":
+		return JumpDiffusion{
+			GBM:            gbm,
+			JumpIntensity:  config.JumpIntensity,
+			JumpMean:       config.JumpMean,
+			JumpVolatility: config.JumpVolatility,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown model %q: use gbm, ou or jump", config.Model)
+	}
+}
+
+// DefaultModels are the price models of the default stocks. The tech stocks
+// are about 60% correlated with each other and 30% with TSLA.
+var DefaultModels = map[string]ModelConfig{
+	"AAPL":  {Model: "gbm", Drift: 0.08, Volatility: 0.25, Sector: "tech", MarketFactor: 0.6, SectorFactor: 0.5},
+	"TSLA":  {Model: "jump", Drift: 0.10, Volatility: 0.50, JumpIntensity: 6, JumpMean: -0.01, JumpVolatility: 0.06, Sector: "auto", MarketFactor: 0.5},
+	"AMZN":  {Model: "gbm", Drift: 0.07, Volatility: 0.30, Sector: "tech", MarketFactor: 0.6, SectorFactor: 0.5},
+	"GOOGL": {Model: "gbm", Drift: 0.06, Volatility: 0.28, Sector: "tech", MarketFactor: 0.6, SectorFactor: 0.5},
+	"MSFT":  {Model: "ou", Mean: 380, Reversion: 4, Volatility: 60, Sector: "tech", MarketFactor: 0.6, SectorFactor: 0.5},
+}
+
+// DefaultModel is used for symbols without a model of their own
+var DefaultModel = ModelConfig{Model: "gbm", Drift: 0.05, Volatility: 0.30, MarketFactor: 0.5}
+
+// ParseModel reads a ModelConfig from JSON, such as an instrument's model
+// parameters, and checks that it describes a valid model
+func ParseModel(data []byte) (ModelConfig, error) {
+	var config ModelConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return ModelConfig{}, err
+	}
+	if _, err := NewModel(config); err != nil {
+		return ModelConfig{}, err
+	}
+	return config, nil
+}
+
+// LoadModels reads per-symbol model configs from a JSON file mapping symbols
+// to ModelConfig objects, on top of DefaultModels
+func LoadModels(path string) (map[string]ModelConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]ModelConfig)
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	models := make(map[string]ModelConfig, len(DefaultModels)+len(loaded))
+	for symbol, config := range DefaultModels {
+		models[symbol] = config
+	}
+	for symbol, config := range loaded {
+		if _, err := NewModel(config); err != nil {
+			return nil, fmt.Errorf("model for %s: %w", symbol, err)
+		}
+		models[symbol] = config
+	}
+	return models, nil
+}
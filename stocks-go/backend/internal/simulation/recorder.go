@@ -0,0 +1,106 @@
+package simulation
+
+import (
+	"encoding/csv"
+	"os"
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/storage"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// csvHeader is the header of recorded and replayed feeds
+var csvHeader = []string{"timestamp", "symbol", "open", "high", "low", "close", "volume"}
+
+// Recorder writes a price feed out as CSV, one row per symbol and tick, in
+// the format a Replay reads back. A row's open is the price before the tick
+// and its close the price after it; its volume is the shares traded since
+// the symbol's previous row.
+type Recorder struct {
+	store  storage.Store
+	file   *os.File
+	writer *csv.Writer
+	volume map[string]storage.Candle // symbol -> daily bar at the previous row
+	mutex  sync.Mutex
+}
+
+// NewRecorder opens path for appending, writing the header if it is a new
+// file, and returns a Recorder that reads traded volume from store
+func NewRecorder(path string, store storage.Store) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	r := &Recorder{
+		store:  store,
+		file:   file,
+		writer: csv.NewWriter(file),
+		volume: make(map[string]storage.Candle),
+	}
+	if info.Size() == 0 {
+		r.writer.Write(csvHeader)
+		r.writer.Flush()
+		if err := r.writer.Error(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Record writes a tick that moved symbol from open to close at the given time
+func (r *Recorder) Record(at time.Time, symbol string, open, close decimal.Decimal) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.writer.Write([]string{
+		at.UTC().Format(time.RFC3339Nano),
+		symbol,
+		open.String(),
+		decimal.Max(open, close).String(),
+		decimal.Min(open, close).String(),
+		close.String(),
+		strconv.Itoa(r.traded(symbol)),
+	})
+	r.writer.Flush()
+}
+
+// traded returns the shares of symbol traded since the last call, from the
+// growth of its daily bar. Callers must hold the mutex.
+func (r *Recorder) traded(symbol string) int {
+	for _, bar := range r.store.CurrentCandles(symbol) {
+		if bar.Interval != "1d" {
+			continue
+		}
+		previous, seen := r.volume[symbol]
+		r.volume[symbol] = bar
+		if !seen {
+			return 0
+		}
+		if !bar.Start.Equal(previous.Start) {
+			return bar.Volume
+		}
+		return bar.Volume - previous.Volume
+	}
+	return 0
+}
+
+// Close flushes and closes the file
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.writer.Flush()
+	if err := r.writer.Error(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
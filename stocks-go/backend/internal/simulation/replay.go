@@ -0,0 +1,415 @@
+package simulation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/storage"
+	"stocks-backend/internal/websocket"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bar is one row of a historical feed: a symbol's prices over a period
+// ending at Time, and the shares traded in it
+type Bar struct {
+	Time   time.Time
+	Symbol string
+	Open   decimal.Decimal
+	High   decimal.Decimal
+	Low    decimal.Decimal
+	Close  decimal.Decimal
+	Volume int
+}
+
+// barTimeLayouts are the timestamp formats a feed may use besides Unix seconds
+var barTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// parseBarTime reads a feed timestamp, taking times without a zone as UTC
+func parseBarTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	for _, layout := range barTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+}
+
+// ReadBars reads a CSV feed with the columns timestamp, symbol, open, high,
+// low, close and volume, as a Recorder writes them. The header row is
+// optional and lines starting with # are skipped. Bars are returned in time
+// order, keeping the file's order within a timestamp.
+func ReadBars(reader io.Reader) ([]Bar, error) {
+	rows := csv.NewReader(reader)
+	rows.Comment = '#'
+	rows.FieldsPerRecord = len(csvHeader)
+	rows.TrimLeadingSpace = true
+
+	bars := make([]Bar, 0)
+	for first := true; ; first = false {
+		record, err := rows.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && strings.EqualFold(record[0], csvHeader[0]) {
+			continue
+		}
+
+		line, _ := rows.FieldPos(0)
+		bar, err := parseBar(record)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		bars = append(bars, bar)
+	}
+
+	sort.SliceStable(bars, func(i, j int) bool { return bars[i].Time.Before(bars[j].Time) })
+	return bars, nil
+}
+
+// parseBar reads a CSV record into a Bar
+func parseBar(record []string) (Bar, error) {
+	t, err := parseBarTime(record[0])
+	if err != nil {
+		return Bar{}, err
+	}
+	bar := Bar{Time: t, Symbol: strings.ToUpper(strings.TrimSpace(record[1]))}
+	if bar.Symbol == "" {
+		return Bar{}, fmt.Errorf("missing symbol")
+	}
+
+	prices := []*decimal.Decimal{&bar.Open, &bar.High, &bar.Low, &bar.Close}
+	for i, price := range prices {
+		value, err := decimal.Parse(record[2+i])
+		if err != nil {
+			return Bar{}, fmt.Errorf("%s: %w", csvHeader[2+i], err)
+		}
+		*price = value
+	}
+	if bar.Close <= 0 {
+		return Bar{}, fmt.Errorf("close must be above 0")
+	}
+
+	if value := strings.TrimSpace(record[6]); value != "" {
+		volume, err := strconv.Atoi(value)
+		if err != nil || volume < 0 {
+			return Bar{}, fmt.Errorf("invalid volume %q", value)
+		}
+		bar.Volume = volume
+	}
+	return bar, nil
+}
+
+// LoadBars reads a CSV feed from a file
+func LoadBars(path string) ([]Bar, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	bars, err := ReadBars(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return bars, nil
+}
+
+// ParseSpeed reads a replay speed such as "1x", "10x" or "2.5". "max"
+// replays as fast as possible and is returned as 0.
+func ParseSpeed(value string) (float64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "max" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("invalid speed %q: use a multiple such as 1x or 10x, or max", value)
+	}
+	return speed, nil
+}
+
+// formatSpeed is the inverse of ParseSpeed
+func formatSpeed(speed float64) string {
+	if speed <= 0 {
+		return "max"
+	}
+	return strconv.FormatFloat(speed, 'f', -1, 64) + "x"
+}
+
+// ReplayOptions control a Replay
+type ReplayOptions struct {
+	Speed float64 // multiple of real time; 0 replays as fast as possible
+	Loop  bool    // start over from the first bar after the last
+}
+
+// ReplayStatus reports where a Replay is
+type ReplayStatus struct {
+	Paused   bool      `json:"paused"`
+	Speed    string    `json:"speed"` // such as "1x", or "max"
+	Loop     bool      `json:"loop"`
+	Time     time.Time `json:"time"`  // time of the bars last replayed, zero before the first
+	Start    time.Time `json:"start"` // time of the first bar
+	End      time.Time `json:"end"`   // time of the last bar
+	Finished bool      `json:"finished"`
+}
+
+// Replay drives the market from a historical feed instead of the simulated
+// models. Bars sharing a timestamp are applied together as one tick: each
+// symbol's price is set to its close, rounded to the tick size, and the tick
+// is broadcast just as the Simulator's are. The wait between ticks is the
+// time between their timestamps divided by the speed. Bars for symbols the
+// store does not list are skipped. When the replay starts, loops or seeks,
+// the price history and bars of its symbols are rewound to the first bar
+// it replays, so that they follow the replay back in time.
+type Replay struct {
+	storage storage.Store
+	hub     *websocket.Hub
+	bars    []Bar
+	symbols []string // symbols in bars
+
+	speed    float64
+	loop     bool
+	paused   bool
+	next     int       // index of the next bar to replay
+	last     time.Time // time of the bars last replayed
+	finished bool
+	skipped  map[string]bool // unknown symbols, logged once
+
+	// Changes bump generation and signal wake so that a pending wait is
+	// recomputed rather than run out
+	generation int
+	wake       chan struct{}
+	stop       chan struct{}
+	stopped    bool
+	mutex      sync.Mutex
+}
+
+// NewReplay creates a Replay of bars, which must be in time order as
+// ReadBars returns them
+func NewReplay(store storage.Store, hub *websocket.Hub, bars []Bar, options ReplayOptions) (*Replay, error) {
+	if len(bars) == 0 {
+		return nil, fmt.Errorf("nothing to replay")
+	}
+	if options.Speed < 0 {
+		return nil, fmt.Errorf("speed cannot be negative")
+	}
+	symbols := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, bar := range bars {
+		if !seen[bar.Symbol] {
+			seen[bar.Symbol] = true
+			symbols = append(symbols, bar.Symbol)
+		}
+	}
+	return &Replay{
+		storage: store,
+		hub:     hub,
+		bars:    bars,
+		symbols: symbols,
+		speed:   options.Speed,
+		loop:    options.Loop,
+		skipped: make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+// Start begins the replay
+func (r *Replay) Start() {
+	go func() {
+		log.Printf("Replay started: %d bars from %s to %s at %s",
+			len(r.bars), r.bars[0].Time.Format(time.RFC3339), r.bars[len(r.bars)-1].Time.Format(time.RFC3339), formatSpeed(r.speed))
+		r.run()
+	}()
+}
+
+// Stop ends the replay
+func (r *Replay) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.stopped {
+		r.stopped = true
+		close(r.stop)
+		log.Println("Replay stopped")
+	}
+}
+
+// Pause holds the replay at the current bar
+func (r *Replay) Pause() {
+	r.change(func() { r.paused = true })
+}
+
+// Resume continues a paused replay
+func (r *Replay) Resume() {
+	r.change(func() { r.paused = false })
+}
+
+// Seek moves the replay to the first bar at or after t, which is replayed
+// without waiting. A finished replay can be sought back into.
+func (r *Replay) Seek(t time.Time) {
+	r.change(func() {
+		r.next = sort.Search(len(r.bars), func(i int) bool { return !r.bars[i].Time.Before(t) })
+		r.last = time.Time{}
+		r.finished = false
+	})
+}
+
+// SetSpeed changes the speed; 0 replays as fast as possible
+func (r *Replay) SetSpeed(speed float64) {
+	if speed < 0 {
+		speed = 0
+	}
+	r.change(func() { r.speed = speed })
+}
+
+// SetLoop sets whether the replay starts over after the last bar
+func (r *Replay) SetLoop(loop bool) {
+	r.change(func() {
+		r.loop = loop
+		if loop {
+			r.finished = false
+		}
+	})
+}
+
+// Status reports the replay's position and settings
+func (r *Replay) Status() ReplayStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return ReplayStatus{
+		Paused:   r.paused,
+		Speed:    formatSpeed(r.speed),
+		Loop:     r.loop,
+		Time:     r.last,
+		Start:    r.bars[0].Time,
+		End:      r.bars[len(r.bars)-1].Time,
+		Finished: r.finished,
+	}
+}
+
+// change applies update under the mutex and interrupts any pending wait
+func (r *Replay) change(update func()) {
+	r.mutex.Lock()
+	update()
+	r.generation++
+	r.mutex.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run replays ticks until stopped
+func (r *Replay) run() {
+	for {
+		r.mutex.Lock()
+		if r.next >= len(r.bars) && r.loop {
+			r.next, r.last = 0, time.Time{}
+		}
+		if r.paused || r.next >= len(r.bars) {
+			if !r.paused && !r.finished {
+				r.finished = true
+				log.Println("Replay finished")
+			}
+			r.mutex.Unlock()
+			select {
+			case <-r.wake:
+				continue
+			case <-r.stop:
+				return
+			}
+		}
+
+		at := r.bars[r.next].Time
+		var wait time.Duration
+		if r.speed > 0 && !r.last.IsZero() {
+			wait = time.Duration(float64(at.Sub(r.last)) / r.speed)
+		}
+		generation := r.generation
+		r.mutex.Unlock()
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-r.wake:
+				timer.Stop()
+				continue
+			case <-r.stop:
+				timer.Stop()
+				return
+			}
+		} else {
+			select {
+			case <-r.stop:
+				return
+			default:
+			}
+		}
+
+		r.mutex.Lock()
+		if generation != r.generation {
+			r.mutex.Unlock()
+			continue
+		}
+		end := r.next
+		for end < len(r.bars) && r.bars[end].Time.Equal(at) {
+			end++
+		}
+		group := r.bars[r.next:end]
+		rewind := r.last.IsZero() // starting, looping or seeking
+		r.next, r.last = end, at
+		r.mutex.Unlock()
+
+		if rewind {
+			for _, symbol := range r.symbols {
+				r.storage.RewindPrices(symbol, at)
+			}
+		}
+		r.apply(at, group)
+	}
+}
+
+// apply sets the prices of a group of bars sharing a timestamp and
+// broadcasts them as a tick
+func (r *Replay) apply(at time.Time, group []Bar) {
+	updatedPrices := make([]storage.StockPrice, 0, len(group))
+	for _, bar := range group {
+		price, exists := r.storage.GetPrice(bar.Symbol)
+		if !exists {
+			r.skip(bar.Symbol)
+			continue
+		}
+		newPrice := decimal.Max(bar.Close.Round(price.TickSize), price.TickSize)
+		changePercent := (newPrice - price.Price).Ratio(price.Price) * 100.0
+
+		updatedPrices = append(updatedPrices, applyPrice(r.storage, bar.Symbol, newPrice, changePercent, at))
+	}
+	if len(updatedPrices) > 0 {
+		broadcastTick(r.storage, r.hub, at, updatedPrices)
+	}
+}
+
+// skip logs the first bar of a symbol the store does not list
+func (r *Replay) skip(symbol string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.skipped[symbol] {
+		r.skipped[symbol] = true
+		log.Printf("Replay skipping unknown symbol %s", symbol)
+	}
+}
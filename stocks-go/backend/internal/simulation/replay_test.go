@@ -0,0 +1,87 @@
+package simulation
+
+import (
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/storage"
+	"stocks-backend/internal/websocket"
+	"testing"
+	"time"
+)
+
+// waitFor polls until done reports true, failing the test after a few
+// seconds
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if done() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// dailyClose returns the close of symbol's daily bar in progress, the last
+// bar a tick updates
+func dailyClose(store storage.Store, symbol string) decimal.Decimal {
+	for _, candle := range store.CurrentCandles(symbol) {
+		if candle.Interval == "1d" {
+			return candle.Close
+		}
+	}
+	return 0
+}
+
+func TestReplayLoopRewindsHistoryAndCandles(t *testing.T) {
+	store, err := storage.OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	defer store.Close()
+	if _, err := store.ListInstrument(storage.Instrument{Symbol: "AAA", Name: "Test", InitialPrice: decimal.New(100, 0)}); err != nil {
+		t.Fatalf("ListInstrument: %v", err)
+	}
+	hub := websocket.NewHub()
+	go hub.Run()
+
+	// The second bar is an hour after the first, so after looping the
+	// replay waits there
+	start := time.Date(2024, 3, 1, 14, 30, 0, 0, time.UTC)
+	bars := []Bar{
+		{Time: start, Symbol: "AAA", Close: decimal.New(101, 0)},
+		{Time: start.Add(time.Hour), Symbol: "AAA", Close: decimal.New(102, 0)},
+	}
+	replay, err := NewReplay(store, hub, bars, ReplayOptions{Speed: 1})
+	if err != nil {
+		t.Fatalf("NewReplay: %v", err)
+	}
+	replay.Start()
+	defer replay.Stop()
+
+	// Play the first pass through, seeking to the second bar rather than
+	// waiting for it, then loop back to the first
+	waitFor(t, "the first bar", func() bool { return dailyClose(store, "AAA") == decimal.New(101, 0) })
+	replay.Seek(bars[1].Time)
+	waitFor(t, "the second bar", func() bool { return dailyClose(store, "AAA") == decimal.New(102, 0) })
+	waitFor(t, "the replay to finish", func() bool { return replay.Status().Finished })
+	replay.SetLoop(true)
+	waitFor(t, "the first bar again", func() bool { return dailyClose(store, "AAA") == decimal.New(101, 0) })
+
+	points, err := store.PriceTicks("AAA", time.Time{}, time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("PriceTicks: %v", err)
+	}
+	if len(points) != 1 || !points[0].Time.Equal(start) || points[0].Price != decimal.New(101, 0) {
+		t.Errorf("history after looping = %+v, want only the first bar", points)
+	}
+
+	for _, interval := range storage.CandleIntervals {
+		candles, err := store.Candles("AAA", interval.Name, time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatalf("Candles(%s): %v", interval.Name, err)
+		}
+		want := start.Truncate(interval.Duration)
+		if len(candles) != 1 || !candles[0].Start.Equal(want) || candles[0].Close != decimal.New(101, 0) {
+			t.Errorf("%s candles after looping = %+v, want one bar at %s closing at 101", interval.Name, candles, want)
+		}
+	}
+}
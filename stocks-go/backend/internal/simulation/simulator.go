@@ -3,31 +3,66 @@ package simulation
 import (
 	"log"
 	"math/rand"
+	"sort"
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/market"
 	"stocks-backend/internal/storage"
 	"stocks-backend/internal/websocket"
 	"time"
 )
 
+// Config controls the price simulation
+type Config struct {
+	Interval time.Duration          // wall-clock time between ticks
+	TimeStep time.Duration          // market time each tick moves prices forward by
+	Seed     int64                  // seeds the random number generator, for reproducible runs
+	Models   map[string]ModelConfig // price model by symbol; see Simulator.modelConfig
+
+	// Correlation, if set, correlates the shocks of its symbols in place of
+	// their models' factor loadings
+	Correlation *CorrelationMatrix
+
+	// Calendar, if set, scales each tick's time step by the activity of the
+	// current session, pausing prices while the market is closed
+	Calendar *market.Calendar
+}
+
+// DefaultConfig ticks every 3 seconds, each tick a 5 minute step of market
+// time, with the DefaultModels. Callers pick the seed.
+var DefaultConfig = Config{
+	Interval: 3 * time.Second,
+	TimeStep: 5 * time.Minute,
+	Models:   DefaultModels,
+}
+
 // Simulator handles the price simulation logic
 type Simulator struct {
-	storage *storage.Storage
+	storage storage.Store
 	hub     *websocket.Hub
 	ticker  *time.Ticker
+	config  Config
+	rng     *rand.Rand
+	models  map[string]cachedModel
+
+	recorder *Recorder // writes the generated prices out, if set
 }
 
 // NewSimulator creates a new Simulator instance
-func NewSimulator(store *storage.Storage, hub *websocket.Hub) *Simulator {
+func NewSimulator(store storage.Store, hub *websocket.Hub, config Config) *Simulator {
 	return &Simulator{
 		storage: store,
 		hub:     hub,
-		ticker:  time.NewTicker(3 * time.Second), // Update every 3 seconds
+		ticker:  time.NewTicker(config.Interval),
+		config:  config,
+		rng:     rand.New(rand.NewSource(config.Seed)),
+		models:  make(map[string]cachedModel),
 	}
 }
 
 // Start begins the price simulation
 func (s *Simulator) Start() {
 	go func() {
-		log.Println("Price simulation started")
+		log.Printf("Price simulation started with seed %d", s.config.Seed)
 		for range s.ticker.C {
 			s.updatePrices()
 		}
@@ -40,39 +75,155 @@ func (s *Simulator) Stop() {
 	log.Println("Price simulation stopped")
 }
 
-// updatePrices randomly updates all stock prices
+// Record writes every tick the simulator generates to recorder
+func (s *Simulator) Record(recorder *Recorder) {
+	s.recorder = recorder
+}
+
+// cachedModel is a price model built from config
+type cachedModel struct {
+	config ModelConfig
+	model  PriceModel
+}
+
+// modelConfig returns the model config of a symbol: the model parameters
+// listed with its instrument, else its entry in Config.Models, else
+// DefaultModel. A config without a sector takes the instrument's.
+func (s *Simulator) modelConfig(symbol string) ModelConfig {
+	config, exists := s.config.Models[symbol]
+	if !exists {
+		config = DefaultModel
+	}
+	instrument, listed := s.storage.Instrument(symbol)
+	if !listed {
+		return config
+	}
+	if len(instrument.Model) > 0 {
+		if own, err := ParseModel(instrument.Model); err == nil {
+			config = own
+		}
+	}
+	if config.Sector == "" {
+		config.Sector = instrument.Sector
+	}
+	return config
+}
+
+// model returns the price model for a symbol's config, building it when the
+// config is first seen or has changed
+func (s *Simulator) model(symbol string, config ModelConfig) PriceModel {
+	if cached, exists := s.models[symbol]; exists && cached.config == config {
+		return cached.model
+	}
+	model, err := NewModel(config)
+	if err != nil {
+		log.Printf("Invalid price model for %s, using the default: %v", symbol, err)
+		model, _ = NewModel(DefaultModel)
+	}
+	s.models[symbol] = cachedModel{config: config, model: model}
+	return model
+}
+
+// updatePrices moves every stock price one time step forward with its
+// model, driven by shocks correlated across symbols
 func (s *Simulator) updatePrices() {
+	now := time.Now()
+	activity := 1.0
+	if s.config.Calendar != nil {
+		activity = s.config.Calendar.Rules(now).Activity
+	}
+	if activity <= 0 {
+		return
+	}
+
 	prices := s.storage.GetAllPrices()
 	updatedPrices := make([]storage.StockPrice, 0, len(prices))
 
+	// Visit symbols in a fixed order so a seed always gives the same prices
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Symbol < prices[j].Symbol })
+	symbols := make([]string, len(prices))
+	configs := make(map[string]ModelConfig, len(prices))
+	for i, price := range prices {
+		symbols[i] = price.Symbol
+		configs[price.Symbol] = s.modelConfig(price.Symbol)
+	}
+	shocks := drawShocks(symbols, func(symbol string) ModelConfig { return configs[symbol] }, s.config.Correlation, s.rng)
+	dt := float64(s.config.TimeStep) * activity / TradingYear
+
 	for _, price := range prices {
-		// Generate a random percentage change between -2% and +2%
-		changePercent := (rand.Float64() - 0.5) * 4.0 // Range: -2.0 to +2.0
-		changeAmount := price.Price * (changePercent / 100.0)
-		newPrice := price.Price + changeAmount
+		next := s.model(price.Symbol, configs[price.Symbol]).Next(price.Price.Float64(), dt, shocks[price.Symbol], s.rng)
+		newPrice := decimal.FromFloat(next).Round(price.TickSize)
 
 		// Ensure price doesn't go below $1
-		if newPrice < 1.0 {
-			newPrice = 1.0
-			changePercent = ((newPrice - price.Price) / price.Price) * 100.0
+		if newPrice < decimal.One {
+			newPrice = decimal.One
 		}
+		// Report the change actually made after rounding to the tick size
+		changePercent := (newPrice - price.Price).Ratio(price.Price) * 100.0
 
 		// Update storage
-		s.storage.UpdatePrice(price.Symbol, newPrice, changePercent)
+		applied := applyPrice(s.storage, price.Symbol, newPrice, changePercent, now)
+		if s.recorder != nil {
+			s.recorder.Record(now, price.Symbol, price.Price, applied.Price)
+		}
 
 		// Add to updated prices list
-		updatedPrices = append(updatedPrices, storage.StockPrice{
-			Symbol: price.Symbol,
-			Price:  newPrice,
-			Change: changePercent,
-		})
+		updatedPrices = append(updatedPrices, applied)
 	}
 
-	// Broadcast updated prices to all WebSocket clients
-	if err := s.hub.Broadcast(map[string]interface{}{
-		"type":   "priceUpdate",
-		"prices": updatedPrices,
-	}); err != nil {
-		log.Printf("Error broadcasting prices: %v", err)
+	broadcastTick(s.storage, s.hub, now, updatedPrices)
+}
+
+// applyPrice updates the price of symbol as observed at the given time and
+// returns the price that took effect, which circuit breakers may have held
+// back
+func applyPrice(store storage.Store, symbol string, price decimal.Decimal, change float64, at time.Time) storage.StockPrice {
+	store.UpdatePrice(symbol, price, change, at)
+	applied := storage.StockPrice{Symbol: symbol, Price: price, Change: change}
+	if current, exists := store.GetPrice(symbol); exists {
+		applied.Price, applied.Change = current.Price, current.Change
+	}
+	return applied
+}
+
+// broadcastTick announces a tick's new prices and the bars in progress to
+// the WebSocket clients subscribed to each symbol, then reports trading
+// halts and resumptions and re-values margin accounts at the new prices,
+// telling each account's owner alone of its margin events
+func broadcastTick(store storage.Store, hub *websocket.Hub, at time.Time, updatedPrices []storage.StockPrice) {
+	for _, price := range updatedPrices {
+		if err := hub.Publish(websocket.ChannelPrices, price.Symbol, map[string]interface{}{
+			"type":   "priceUpdate",
+			"time":   at,
+			"prices": []storage.StockPrice{price},
+		}); err != nil {
+			log.Printf("Error broadcasting prices: %v", err)
+		}
+
+		// Followed by the bars in progress, one per interval
+		if err := hub.Publish(websocket.ChannelCandles, price.Symbol, map[string]interface{}{
+			"type":    "candleUpdate",
+			"candles": store.CurrentCandles(price.Symbol),
+		}); err != nil {
+			log.Printf("Error broadcasting candles: %v", err)
+		}
+	}
+
+	for _, event := range store.CheckHalts(time.Now()) {
+		if err := hub.Publish(websocket.ChannelHalts, event.Halt.Symbol, map[string]interface{}{
+			"type": event.Type,
+			"halt": event.Halt,
+		}); err != nil {
+			log.Printf("Error broadcasting %s: %v", event.Type, err)
+		}
+	}
+
+	for _, event := range store.CheckMargins() {
+		if err := hub.PublishUser(event.Username, map[string]interface{}{
+			"type":  "margin",
+			"event": event,
+		}); err != nil {
+			log.Printf("Error broadcasting margin event: %v", err)
+		}
 	}
 }
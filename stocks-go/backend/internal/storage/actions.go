@@ -0,0 +1,385 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"stocks-backend/internal/decimal"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CorporateAction is a stock split or cash dividend on an instrument,
+// scheduled to take effect at EffectiveAt
+type CorporateAction struct {
+	ID     string `json:"id"`
+	Symbol string `json:"symbol"`
+	Type   string `json:"type"` // "split" or "dividend"
+
+	// Splits give To new shares for every From held: 2 for 1 doubles
+	// positions and halves prices, while 1 for 10 is a reverse split
+	To   int `json:"to,omitempty"`
+	From int `json:"from,omitempty"`
+
+	// Dividends pay Amount per share to holders of record
+	Amount decimal.Decimal `json:"amount,omitempty"`
+
+	EffectiveAt time.Time  `json:"effectiveAt"` // when a split takes effect, or a dividend's record date
+	Status      string     `json:"status"`      // "scheduled", "applied" or "cancelled"
+	ScheduledAt time.Time  `json:"scheduledAt"`
+	AppliedAt   *time.Time `json:"appliedAt,omitempty"`
+	Accounts    int        `json:"accounts"` // positions split or paid when applied
+}
+
+// Errors returned when looking up or cancelling a corporate action
+var (
+	ErrActionNotFound     = errors.New("Corporate action not found")
+	ErrActionNotScheduled = errors.New("Corporate action has already been applied or cancelled")
+)
+
+// CorporateActions returns the corporate actions on symbol, or on every
+// symbol when it is empty, by effective time
+func (s *Storage) CorporateActions(symbol string) []CorporateAction {
+	s.ordersMutex.RLock()
+	defer s.ordersMutex.RUnlock()
+
+	actions := make([]CorporateAction, 0)
+	for _, action := range s.actions {
+		if symbol == "" || action.Symbol == symbol {
+			actions = append(actions, *action)
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		if !actions[i].EffectiveAt.Equal(actions[j].EffectiveAt) {
+			return actions[i].EffectiveAt.Before(actions[j].EffectiveAt)
+		}
+		return actions[i].ScheduledAt.Before(actions[j].ScheduledAt)
+	})
+	return actions
+}
+
+// ScheduleCorporateAction checks a split or dividend on a listed symbol and
+// schedules it. An action without an effective time takes effect straight
+// away.
+func (s *Storage) ScheduleCorporateAction(action CorporateAction) (CorporateAction, error) {
+	action.Symbol = strings.ToUpper(strings.TrimSpace(action.Symbol))
+	action.Type = strings.ToLower(strings.TrimSpace(action.Type))
+	switch action.Type {
+	case "split":
+		if action.To <= 0 || action.From <= 0 || action.To == action.From {
+			return CorporateAction{}, fmt.Errorf("splits need different whole numbers of shares to and from, such as 2 for 1")
+		}
+		action.Amount = decimal.Zero
+	case "dividend":
+		if action.Amount <= 0 {
+			return CorporateAction{}, fmt.Errorf("dividends need an amount per share above 0")
+		}
+		action.To, action.From = 0, 0
+	default:
+		return CorporateAction{}, fmt.Errorf("type must be 'split' or 'dividend'")
+	}
+
+	now := time.Now()
+	if action.EffectiveAt.IsZero() {
+		action.EffectiveAt = now
+	}
+	action.ID = uuid.New().String()
+	action.Status = "scheduled"
+	action.ScheduledAt = now
+	action.AppliedAt = nil
+	action.Accounts = 0
+
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+	if _, listed := s.Instrument(action.Symbol); !listed {
+		return CorporateAction{}, ErrStockNotFound
+	}
+	s.actions[action.ID] = &action
+	log.Printf("Scheduled %s on %s for %s", action.describe(), action.Symbol, action.EffectiveAt.Format(time.RFC3339))
+	return action, nil
+}
+
+// CancelCorporateAction cancels a corporate action that has not taken effect
+func (s *Storage) CancelCorporateAction(id string) (CorporateAction, error) {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+
+	action, exists := s.actions[id]
+	if !exists {
+		return CorporateAction{}, ErrActionNotFound
+	}
+	if action.Status != "scheduled" {
+		return CorporateAction{}, ErrActionNotScheduled
+	}
+	action.Status = "cancelled"
+	return *action, nil
+}
+
+// describe names an action for logs and ledger memos
+func (a *CorporateAction) describe() string {
+	if a.Type == "split" {
+		return fmt.Sprintf("%d for %d split", a.To, a.From)
+	}
+	return fmt.Sprintf("%s dividend", a.Amount)
+}
+
+// ApplyCorporateActions applies the scheduled actions that have fallen due
+// by now, oldest first, meant to be called periodically. Actions on symbols
+// no longer listed are cancelled. It returns the actions applied or
+// cancelled; the owners of open orders a split rescaled or cancelled are
+// notified through NotifyUserEvents.
+func (s *Storage) ApplyCorporateActions(now time.Time) []CorporateAction {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+
+	due := make([]*CorporateAction, 0)
+	for _, action := range s.actions {
+		if action.Status == "scheduled" && !action.EffectiveAt.After(now) {
+			due = append(due, action)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].EffectiveAt.Equal(due[j].EffectiveAt) {
+			return due[i].EffectiveAt.Before(due[j].EffectiveAt)
+		}
+		return due[i].ScheduledAt.Before(due[j].ScheduledAt)
+	})
+
+	applied := make([]CorporateAction, 0, len(due))
+	for _, action := range due {
+		if _, listed := s.Instrument(action.Symbol); !listed {
+			action.Status = "cancelled"
+			applied = append(applied, *action)
+			continue
+		}
+		switch action.Type {
+		case "split":
+			s.split(action, now)
+		case "dividend":
+			s.payDividend(action)
+		}
+		action.Status = "applied"
+		action.AppliedAt = &now
+		applied = append(applied, *action)
+		log.Printf("Applied %s on %s to %d accounts", action.describe(), action.Symbol, action.Accounts)
+	}
+	s.settleGroups()
+	return applied
+}
+
+// cancelCorporateActions cancels the scheduled actions on symbol. Callers
+// must hold ordersMutex.
+func (s *Storage) cancelCorporateActions(symbol string) {
+	for _, action := range s.actions {
+		if action.Symbol == symbol && action.Status == "scheduled" {
+			action.Status = "cancelled"
+		}
+	}
+}
+
+// holders returns every account, by username, for corporate actions and
+// delistings to visit. Callers must not hold accountsMutex.
+func (s *Storage) holders() []*UserAccount {
+	s.accountsMutex.RLock()
+	accounts := make([]*UserAccount, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, account)
+	}
+	s.accountsMutex.RUnlock()
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Username < accounts[j].Username })
+	return accounts
+}
+
+// payDividend credits every holder of the action's symbol its amount per
+// share; short positions pay it instead. Callers must hold ordersMutex.
+func (s *Storage) payDividend(action *CorporateAction) {
+	for _, account := range s.holders() {
+		account.mutex.Lock()
+		if quantity := account.Portfolio[action.Symbol]; quantity != 0 {
+			amount := action.Amount.MulInt(quantity)
+			s.post(LedgerEntry{
+				Type:      "dividend",
+				Reference: action.ID,
+				Memo:      fmt.Sprintf("%s dividend of %s on %d shares", action.Symbol, action.Amount, quantity),
+				Postings: []Posting{
+					{Account: cashAccount(account.Username), Amount: amount},
+					{Account: houseIssuers, Amount: -amount},
+				},
+			}, account)
+			action.Accounts++
+		}
+		account.mutex.Unlock()
+	}
+}
+
+// split applies a stock split: positions, lots and open order quantities
+// are multiplied by To/From, and the price, open order prices, price
+// history and bars divided by it, so that values and charts carry on
+// unchanged. Fractional shares left by the split are paid out in cash at
+// the price before the split. Open orders keep their place in the queue,
+// and orders rounded down to nothing are cancelled. Callers must hold
+// ordersMutex.
+func (s *Storage) split(action *CorporateAction, now time.Time) {
+	symbol, to, from := action.Symbol, action.To, action.From
+	current, _ := s.GetPrice(symbol)
+	tick := current.TickSize
+	adjust := func(price decimal.Decimal) decimal.Decimal { return price.MulInt(from).DivInt(to) }
+	adjustTick := func(price decimal.Decimal) decimal.Decimal {
+		if price == 0 {
+			return 0
+		}
+		return decimal.Max(adjust(price).Round(tick), tick)
+	}
+
+	// Take resting orders off the book, in queue order, and put them back
+	// in the same order once rescaled
+	open := make([]*Order, 0)
+	for _, order := range s.orders {
+		if order.Symbol == symbol && order.IsOpen() {
+			open = append(open, order)
+		}
+	}
+	sort.SliceStable(open, func(i, j int) bool { return open[i].seq < open[j].seq })
+	resting := make(map[*Order]bool)
+	for _, order := range open {
+		if _, ok := s.unrest(order); ok {
+			resting[order] = true
+		}
+	}
+
+	for _, order := range open {
+		order.Quantity = order.Quantity * to / from
+		order.FilledQuantity = order.FilledQuantity * to / from
+		order.Price = adjustTick(order.Price)
+		order.StopPrice = adjustTick(order.StopPrice)
+		order.TrailAmount = adjustTick(order.TrailAmount)
+		order.TrailReference = adjustTick(order.TrailReference)
+		order.AvgFillPrice = adjust(order.AvgFillPrice)
+		order.UpdatedAt = &now
+
+		if order.Remaining() <= 0 {
+			delete(resting, order)
+			s.withdraw(order, "cancelled", now)
+		} else if resting[order] {
+			s.reserve(order, order.Remaining())
+			s.books[symbol].Add(order.ID, order.Username, order.Side, order.Price, order.Remaining())
+		}
+		s.touch(order)
+	}
+
+	for _, account := range s.holders() {
+		account.mutex.Lock()
+		if quantity := account.Portfolio[symbol]; quantity != 0 {
+			shares := quantity * to / from
+			// The fraction of a share left over, as a number of old shares
+			// times To, is bought back at the old price
+			cash := current.Price.MulInt(quantity*to - shares*from).DivInt(to)
+			if cash != 0 {
+				s.post(LedgerEntry{
+					Type:      "split",
+					Reference: action.ID,
+					Memo:      fmt.Sprintf("Cash for fractional %s shares in %s", symbol, action.describe()),
+					Postings: []Posting{
+						{Account: cashAccount(account.Username), Amount: cash},
+						{Account: houseIssuers, Amount: -cash},
+					},
+				}, account)
+			}
+			account.splitLots(symbol, to, from, shares, cash)
+			if shares == 0 {
+				delete(account.Portfolio, symbol)
+			} else {
+				account.Portfolio[symbol] = shares
+			}
+			s.accountChanged(account)
+			action.Accounts++
+		}
+		account.mutex.Unlock()
+	}
+
+	s.pricesMutex.Lock()
+	if price, exists := s.prices[symbol]; exists {
+		price.Price = adjustTick(price.Price)
+		history := make([]decimal.Decimal, len(price.PriceHistory))
+		for i, p := range price.PriceHistory {
+			history[i] = adjustTick(p)
+		}
+		price.PriceHistory = history
+	}
+	s.pricesMutex.Unlock()
+	s.ticks.Adjust(symbol, adjust)
+
+	s.candlesMutex.Lock()
+	for _, series := range s.candles[symbol] {
+		for i := range series {
+			bar := &series[i]
+			bar.Open, bar.High, bar.Low, bar.Close = adjust(bar.Open), adjust(bar.High), adjust(bar.Low), adjust(bar.Close)
+			bar.Volume = bar.Volume * to / from
+		}
+	}
+	s.candlesMutex.Unlock()
+
+	s.haltsMutex.Lock()
+	if halt, halted := s.halts[symbol]; halted {
+		halt.Reference, halt.Price = adjust(halt.Reference), adjustTick(halt.Price)
+		halt.Lower, halt.Upper = adjustTick(halt.Lower), adjustTick(halt.Upper)
+	}
+	s.haltsMutex.Unlock()
+}
+
+// splitLots rescales the lots of symbol for a split of to for from that
+// leaves shares held. The lots keep their cost, less the part of it that
+// goes with a fractional share paid out as cash, whose difference from the
+// cash is realized. Callers must hold the account mutex.
+func (a *UserAccount) splitLots(symbol string, to, from, shares int, cash decimal.Decimal) {
+	lots := a.Lots[symbol]
+	scaled := 0 // shares after the split including the fraction, times from
+	for _, lot := range lots {
+		scaled += lot.Quantity * to
+	}
+	if scaled == 0 {
+		return
+	}
+
+	remaining := shares * from
+	removed, assigned := decimal.Zero, 0
+	for i := range lots {
+		lot := &lots[i]
+		cost := lot.Cost
+		if remaining != scaled {
+			lot.Cost = cost.MulInt(remaining).DivInt(scaled)
+		}
+		removed += cost - lot.Cost
+		lot.Quantity = lot.Quantity * to / from
+		assigned += lot.Quantity
+	}
+	// Rounding each lot down can leave whole shares over, which the newest
+	// lot takes
+	lots[len(lots)-1].Quantity += shares - assigned
+
+	kept := lots[:0]
+	orphaned := decimal.Zero
+	for _, lot := range lots {
+		if lot.Quantity == 0 {
+			orphaned += lot.Cost
+			continue
+		}
+		kept = append(kept, lot)
+	}
+	if len(kept) == 0 {
+		delete(a.Lots, symbol)
+	} else {
+		kept[len(kept)-1].Cost += orphaned
+		a.Lots[symbol] = kept
+	}
+
+	if cash != 0 || removed != 0 {
+		if a.RealizedPnL == nil {
+			a.RealizedPnL = make(map[string]decimal.Decimal)
+		}
+		a.RealizedPnL[symbol] += cash - removed
+	}
+}
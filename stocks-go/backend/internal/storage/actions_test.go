@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitLots(t *testing.T) {
+	start := time.Date(2024, 1, 2, 14, 30, 0, 0, time.UTC)
+	lot := func(quantity int, cost string, day int) Lot {
+		return Lot{Quantity: quantity, Cost: dec(cost), OpenedAt: start.AddDate(0, 0, day)}
+	}
+
+	tests := []struct {
+		name     string
+		to, from int
+		lots     []Lot
+		shares   int    // shares held after the split
+		cash     string // paid for the fractional share
+		want     []Lot
+		realized string
+	}{
+		{
+			name: "forward split keeps the cost", to: 2, from: 1,
+			lots:   []Lot{lot(10, "100", 0), lot(5, "60", 1)},
+			shares: 30, cash: "0",
+			want:     []Lot{lot(20, "100", 0), lot(10, "60", 1)},
+			realized: "0",
+		},
+		{
+			name: "the fractional share is realized against its cost", to: 1, from: 3,
+			// 10 old shares make 3 and a third, the third paid at 12
+			lots:   []Lot{lot(10, "100", 0)},
+			shares: 3, cash: "12",
+			want:     []Lot{lot(3, "90", 0)},
+			realized: "2",
+		},
+		{
+			name: "whole shares left by rounding go to the newest lot", to: 3, from: 2,
+			lots:   []Lot{lot(3, "30", 0), lot(3, "36", 1)},
+			shares: 9, cash: "0",
+			want:     []Lot{lot(4, "30", 0), lot(5, "36", 1)},
+			realized: "0",
+		},
+		{
+			name: "lots rounded to nothing pass their cost on", to: 1, from: 3,
+			lots:   []Lot{lot(2, "20", 0), lot(4, "48", 1)},
+			shares: 2, cash: "0",
+			want:     []Lot{lot(2, "68", 1)},
+			realized: "0",
+		},
+		{
+			name: "shorts are bought back for the fraction", to: 1, from: 2,
+			// -5 old shares make -2 and a half, the half bought back at 20
+			lots:   []Lot{lot(-5, "-100", 0)},
+			shares: -2, cash: "-20",
+			want:     []Lot{lot(-2, "-80", 0)},
+			realized: "0",
+		},
+		{
+			name: "a position paid out entirely is closed", to: 1, from: 10,
+			lots:   []Lot{lot(5, "50", 0)},
+			shares: 0, cash: "55",
+			want:     nil,
+			realized: "5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := &UserAccount{Username: "alice", Lots: map[string][]Lot{"AAA": tt.lots}}
+			account.splitLots("AAA", tt.to, tt.from, tt.shares, dec(tt.cash))
+
+			lots, open := account.Lots["AAA"]
+			if open != (tt.want != nil) || !reflect.DeepEqual(lots, tt.want) {
+				t.Errorf("lots = %+v, want %+v", lots, tt.want)
+			}
+			if got := account.RealizedPnL["AAA"]; got != dec(tt.realized) {
+				t.Errorf("realized = %s, want %s", got, tt.realized)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"stocks-backend/internal/decimal"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GetOrder returns a single order owned by username
+func (s *Storage) GetOrder(username, orderID string) (Order, error) {
+	s.ordersMutex.RLock()
+	defer s.ordersMutex.RUnlock()
+
+	order, err := s.ownedOrder(username, orderID)
+	if err != nil {
+		return Order{}, err
+	}
+	return *order, nil
+}
+
+// ownedOrder looks up an order and checks its owner; callers must hold ordersMutex
+func (s *Storage) ownedOrder(username, orderID string) (*Order, error) {
+	order, exists := s.orderIndex[orderID]
+	if !exists {
+		return nil, ErrOrderNotFound
+	}
+	if order.Username != username {
+		return nil, ErrNotOrderOwner
+	}
+	return order, nil
+}
+
+// openOrder looks up an order that is still armed or resting on the book
+func (s *Storage) openOrder(username, orderID string) (*Order, error) {
+	order, err := s.ownedOrder(username, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if !order.IsOpen() {
+		return nil, ErrOrderNotOpen
+	}
+	return order, nil
+}
+
+// IsOpen reports whether the order can still execute
+func (o *Order) IsOpen() bool {
+	switch o.Status {
+	case "held", "armed", "queued", "pending", "partially_filled":
+		return true
+	}
+	return false
+}
+
+// CancelOrder withdraws an open order from the book, or disarms a stop.
+// Cancelling part of an order group cascades to the rest of the group.
+func (s *Storage) CancelOrder(username, orderID string) (Order, error) {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+
+	order, err := s.openOrder(username, orderID)
+	if err != nil {
+		return Order{}, err
+	}
+
+	s.withdraw(order, "cancelled", time.Now())
+	s.touch(order)
+	s.settleGroups()
+	return *order, nil
+}
+
+// withdraw closes an open order with status, releasing whatever it holds.
+// Callers must hold ordersMutex.
+func (s *Storage) withdraw(order *Order, status string, at time.Time) {
+	switch order.Status {
+	case "armed":
+		s.disarm(order)
+	case "queued":
+		s.unqueue(order)
+	case "pending", "partially_filled":
+		s.unrest(order)
+	}
+	order.Status = status
+	order.UpdatedAt = &at
+	s.orderChanged(order)
+}
+
+// AmendOrder changes the price and/or total quantity of an open limit order.
+// Reducing the quantity keeps the order's place in the queue. Changing the
+// price or increasing the quantity loses priority: the original order is
+// marked "replaced" and a new order for the unfilled quantity is submitted,
+// which may execute immediately if its new price crosses the book. If the
+// replacement is rejected the original is left as it was, in its place.
+// Armed stops and held or queued orders are not on the book and cannot be
+// amended. It returns the order that is live after the amendment.
+func (s *Storage) AmendOrder(username, orderID string, price decimal.Decimal, quantity int) (Order, error) {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+
+	order, err := s.openOrder(username, orderID)
+	if err != nil {
+		return Order{}, err
+	}
+	if halt, halted := s.halted(order.Symbol); halted {
+		return Order{}, haltError(halt)
+	}
+
+	book := s.books[order.Symbol]
+	if _, resting := book.Get(order.ID); !resting {
+		return Order{}, ErrNotAmendable
+	}
+	filled := order.FilledQuantity
+	remaining := order.Remaining()
+
+	if price <= 0 {
+		price = order.Price
+	}
+	if quantity <= 0 {
+		quantity = order.Quantity
+	}
+	if quantity <= filled {
+		return Order{}, &OrderError{"Quantity must be greater than the filled quantity"}
+	}
+
+	// Quantity reductions are applied in place and keep time priority
+	if price == order.Price && quantity <= order.Quantity {
+		s.reduceResting(order, order.Quantity-quantity)
+		now := time.Now()
+		order.Quantity = quantity
+		order.UpdatedAt = &now
+		s.orderChanged(order)
+		return *order, nil
+	}
+
+	replacement := &Order{
+		ID:         uuid.New().String(),
+		Username:   order.Username,
+		Symbol:     order.Symbol,
+		Side:       order.Side,
+		OrderType:  order.OrderType,
+		Quantity:   quantity - filled,
+		Price:      price,
+		CreatedAt:  time.Now(),
+		ReplacesID: order.ID,
+
+		TimeInForce: order.TimeInForce,
+		ExpiresAt:   order.ExpiresAt,
+
+		GroupID:   order.GroupID,
+		GroupRole: order.GroupRole,
+	}
+
+	// The original's hold is released first so the same funds can back
+	// the replacement
+	s.release(order, remaining)
+	if err := s.checkFunds(replacement, replacement.Quantity, price); err != nil {
+		s.reserve(order, remaining)
+		return Order{}, err
+	}
+
+	// Take the original off the book so the replacement cannot trade
+	// against it, and put it back in its place if the replacement is
+	// rejected, which happens before it trades
+	entry, _ := book.Remove(order.ID)
+	if err := s.submit(replacement); err != nil {
+		s.reserve(order, remaining)
+		book.Restore(entry)
+		return Order{}, err
+	}
+
+	order.Status = "replaced"
+	order.ReplacedBy = replacement.ID
+	order.UpdatedAt = &replacement.CreatedAt
+	s.orderChanged(order)
+
+	// The replacement takes over the original's place in its group
+	if group, ok := s.groups[order.GroupID]; ok {
+		group.OrderIDs = append(group.OrderIDs, replacement.ID)
+	}
+	s.settleGroups()
+	return *replacement, nil
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"stocks-backend/internal/decimal"
+	"time"
+)
+
+// Candle is an OHLCV bar for one symbol and interval. Prices come from the
+// simulated market's ticks and volume from executed trades.
+type Candle struct {
+	Symbol   string          `json:"symbol"`
+	Interval string          `json:"interval"`
+	Start    time.Time       `json:"start"`
+	Open     decimal.Decimal `json:"open"`
+	High     decimal.Decimal `json:"high"`
+	Low      decimal.Decimal `json:"low"`
+	Close    decimal.Decimal `json:"close"`
+	Volume   int             `json:"volume"` // shares traded
+	Trades   int             `json:"trades"`
+}
+
+// CandleInterval is a bar length and how many bars of it are kept
+type CandleInterval struct {
+	Name     string
+	Duration time.Duration
+	Keep     int
+}
+
+// CandleIntervals are the intervals every symbol is aggregated at. Bars
+// start on multiples of the interval since the zero time, so daily bars
+// run from midnight UTC.
+var CandleIntervals = []CandleInterval{
+	{Name: "1m", Duration: time.Minute, Keep: 24 * 60},
+	{Name: "5m", Duration: 5 * time.Minute, Keep: 7 * 24 * 12},
+	{Name: "1h", Duration: time.Hour, Keep: 90 * 24},
+	{Name: "1d", Duration: 24 * time.Hour, Keep: 10 * 365},
+}
+
+// Errors returned when looking up candles and price history
+var (
+	ErrStockNotFound   = errors.New("Stock not found")
+	ErrUnknownInterval = errors.New("Interval must be 1m, 5m, 1h or 1d")
+)
+
+// candleSeries holds one symbol's bars at one interval, oldest first. The
+// last bar is the one in progress.
+type candleSeries []Candle
+
+// bar returns the bar starting at start, opening a new one at open if the
+// series has not reached it yet. It returns nil for times before the last bar.
+func (c *candleSeries) bar(interval CandleInterval, symbol string, start time.Time, open decimal.Decimal) *Candle {
+	bars := *c
+	if n := len(bars); n > 0 {
+		if last := &bars[n-1]; last.Start.Equal(start) {
+			return last
+		} else if start.Before(last.Start) {
+			return nil
+		}
+	}
+
+	bars = append(bars, Candle{
+		Symbol:   symbol,
+		Interval: interval.Name,
+		Start:    start,
+		Open:     open,
+		High:     open,
+		Low:      open,
+		Close:    open,
+	})
+	if len(bars) > interval.Keep {
+		bars = append(bars[:0], bars[len(bars)-interval.Keep:]...)
+	}
+	*c = bars
+	return &bars[len(bars)-1]
+}
+
+// recordTick folds a price observed at the given time into the symbol's
+// bars, and notes the time as the symbol's feed time
+func (s *Storage) recordTick(symbol string, price decimal.Decimal, at time.Time) {
+	s.candlesMutex.Lock()
+	defer s.candlesMutex.Unlock()
+
+	s.feedTime[symbol] = at
+	for i, interval := range CandleIntervals {
+		series := s.series(symbol, i)
+		bar := series.bar(interval, symbol, at.UTC().Truncate(interval.Duration), price)
+		if bar == nil {
+			continue
+		}
+		bar.High = decimal.Max(bar.High, price)
+		bar.Low = decimal.Min(bar.Low, price)
+		bar.Close = price
+	}
+}
+
+// recordVolume adds an executed trade to the volume of the symbol's bars.
+// A trade before the first tick of a bar opens it at the market price.
+// Trades are placed at the time of the symbol's latest tick rather than when
+// they executed, so that while history is replayed they land in the bar of
+// the replayed tick, however the replay has moved back and forth.
+func (s *Storage) recordVolume(trade Trade) {
+	open := trade.Price
+	if price, exists := s.GetPrice(trade.Symbol); exists {
+		open = price.Price
+	}
+
+	s.candlesMutex.Lock()
+	defer s.candlesMutex.Unlock()
+
+	at, ticked := s.feedTime[trade.Symbol]
+	if !ticked {
+		at = trade.ExecutedAt
+	}
+	for i, interval := range CandleIntervals {
+		series := s.series(trade.Symbol, i)
+		if bar := series.bar(interval, trade.Symbol, at.UTC().Truncate(interval.Duration), open); bar != nil {
+			bar.Volume += trade.Quantity
+			bar.Trades++
+		}
+	}
+}
+
+// series returns a symbol's bars at CandleIntervals[i], creating them if
+// needed. Callers must hold candlesMutex.
+func (s *Storage) series(symbol string, i int) *candleSeries {
+	all, exists := s.candles[symbol]
+	if !exists {
+		all = make([]candleSeries, len(CandleIntervals))
+		s.candles[symbol] = all
+	}
+	return &all[i]
+}
+
+// Candles returns a symbol's bars at interval that start in [from, to),
+// oldest first. A zero from or to leaves that end open.
+func (s *Storage) Candles(symbol, interval string, from, to time.Time) ([]Candle, error) {
+	index := -1
+	for i, candidate := range CandleIntervals {
+		if candidate.Name == interval {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, ErrUnknownInterval
+	}
+	if _, exists := s.GetPrice(symbol); !exists {
+		return nil, ErrStockNotFound
+	}
+
+	s.candlesMutex.RLock()
+	defer s.candlesMutex.RUnlock()
+
+	var bars candleSeries
+	if all, exists := s.candles[symbol]; exists {
+		bars = all[index]
+	}
+	first := 0
+	if !from.IsZero() {
+		first = sort.Search(len(bars), func(i int) bool { return !bars[i].Start.Before(from) })
+	}
+	last := len(bars)
+	if !to.IsZero() {
+		last = sort.Search(len(bars), func(i int) bool { return !bars[i].Start.Before(to) })
+	}
+
+	candles := make([]Candle, 0)
+	if first < last {
+		candles = append(candles, bars[first:last]...)
+	}
+	return candles, nil
+}
+
+// CurrentCandles returns the bars in progress for a symbol, one per interval
+func (s *Storage) CurrentCandles(symbol string) []Candle {
+	s.candlesMutex.RLock()
+	defer s.candlesMutex.RUnlock()
+
+	candles := make([]Candle, 0, len(CandleIntervals))
+	for _, bars := range s.candles[symbol] {
+		if len(bars) > 0 {
+			candles = append(candles, bars[len(bars)-1])
+		}
+	}
+	return candles
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordVolumeOnReplayedBars(t *testing.T) {
+	start := time.Date(2024, 3, 1, 14, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		ticks  []time.Duration // after start; a negative one is sought back to
+		volume map[time.Duration]int
+	}{
+		{
+			name:   "trades land in the bar of the latest tick",
+			ticks:  []time.Duration{0, time.Minute},
+			volume: map[time.Duration]int{0: 0, time.Minute: 5},
+		},
+		{
+			name:   "after seeking back",
+			ticks:  []time.Duration{0, time.Hour, -time.Hour},
+			volume: map[time.Duration]int{-time.Hour: 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStorage(t, "alice")
+			var last time.Time
+			for _, offset := range tt.ticks {
+				at := start.Add(offset)
+				if at.Before(last) {
+					s.RewindPrices("AAA", at)
+				}
+				s.UpdatePrice("AAA", dec("100"), 0, at)
+				last = at
+			}
+			submit(t, s, "alice", "buy", "market", 5, "")
+
+			candles, _ := s.Candles("AAA", "1m", time.Time{}, time.Time{})
+			if len(candles) != len(tt.volume) {
+				t.Fatalf("candles = %+v, want %d bars", candles, len(tt.volume))
+			}
+			for _, candle := range candles {
+				want, ok := tt.volume[candle.Start.Sub(start)]
+				if !ok || candle.Volume != want {
+					t.Errorf("bar at %s has volume %d, want %d", candle.Start, candle.Volume, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"sort"
+	"stocks-backend/internal/decimal"
+	"time"
+)
+
+// Cost basis methods choose which lots a closing fill consumes
+const (
+	CostBasisFIFO    = "fifo"    // oldest lots first
+	CostBasisLIFO    = "lifo"    // newest lots first
+	CostBasisAverage = "average" // one lot per holding at the average cost
+)
+
+// IsCostBasisMethod reports whether method is a supported cost basis method
+func IsCostBasisMethod(method string) bool {
+	return method == CostBasisFIFO || method == CostBasisLIFO || method == CostBasisAverage
+}
+
+// Lot is a block of shares opened by one fill. Lots of a holding are all
+// long or all short, and their quantities add up to the portfolio quantity.
+type Lot struct {
+	Quantity int             `json:"quantity"` // negative for short lots
+	Cost     decimal.Decimal `json:"cost"`     // cash paid to open, including commission; negative for shorts
+	OpenedAt time.Time       `json:"openedAt"`
+}
+
+// SetCostBasisMethod sets how closing fills are matched against open lots
+func (s *Storage) SetCostBasisMethod(method string) {
+	s.accountsMutex.Lock()
+	defer s.accountsMutex.Unlock()
+	s.costBasis = method
+}
+
+// costBasisMethod returns the current cost basis method
+func (s *Storage) costBasisMethod() string {
+	s.accountsMutex.RLock()
+	defer s.accountsMutex.RUnlock()
+	if s.costBasis == "" {
+		return CostBasisFIFO
+	}
+	return s.costBasis
+}
+
+// bookFill updates an account's lots and realized P&L for a fill of
+// quantity shares of symbol (negative for sells) at price, which cost fee in
+// commission. The fill first closes lots on the other side, chosen by
+// method, realizing the difference between the cash it brings in and their
+// cost; any quantity left over opens a new lot. The fill's cash is split
+// across the lots it closes and opens in proportion to their quantities.
+// Callers must hold the account mutex.
+func (a *UserAccount) bookFill(symbol string, quantity int, price, fee decimal.Decimal, method string, at time.Time) {
+	if quantity == 0 {
+		return
+	}
+	if a.Lots == nil {
+		a.Lots = make(map[string][]Lot)
+	}
+	if a.RealizedPnL == nil {
+		a.RealizedPnL = make(map[string]decimal.Decimal)
+	}
+
+	size, direction := quantity, 1
+	if quantity < 0 {
+		size, direction = -quantity, -1
+	}
+	cash := -price.MulInt(quantity) - fee // change to the account's cash
+	unallocated := cash
+
+	lots := a.Lots[symbol]
+	if method == CostBasisAverage {
+		lots = averaged(lots)
+	}
+
+	remaining := size
+	for remaining > 0 && len(lots) > 0 && (lots[0].Quantity > 0) != (quantity > 0) {
+		i := 0
+		if method == CostBasisLIFO {
+			i = len(lots) - 1
+		}
+		lot := &lots[i]
+		held := lot.Quantity * -direction
+
+		q := remaining
+		if held < q {
+			q = held
+		}
+		removed := lot.Cost
+		if q < held {
+			removed = lot.Cost.MulInt(q).DivInt(held)
+		}
+		proceeds := unallocated
+		if q < remaining {
+			proceeds = cash.MulInt(q).DivInt(size)
+		}
+
+		a.RealizedPnL[symbol] += proceeds - removed
+		unallocated -= proceeds
+		remaining -= q
+
+		lot.Quantity += direction * q
+		lot.Cost -= removed
+		if lot.Quantity == 0 {
+			lots = append(lots[:i], lots[i+1:]...)
+		}
+	}
+
+	if remaining > 0 {
+		lots = append(lots, Lot{Quantity: direction * remaining, Cost: -unallocated, OpenedAt: at})
+		if method == CostBasisAverage {
+			lots = averaged(lots)
+		}
+	}
+
+	if len(lots) == 0 {
+		delete(a.Lots, symbol)
+	} else {
+		a.Lots[symbol] = lots
+	}
+}
+
+// averaged merges lots into one at their combined cost, dated by the oldest
+func averaged(lots []Lot) []Lot {
+	if len(lots) < 2 {
+		return lots
+	}
+	merged := lots[0]
+	for _, lot := range lots[1:] {
+		merged.Quantity += lot.Quantity
+		merged.Cost += lot.Cost
+		if lot.OpenedAt.Before(merged.OpenedAt) {
+			merged.OpenedAt = lot.OpenedAt
+		}
+	}
+	return []Lot{merged}
+}
+
+// Position reports a holding's cost basis and P&L. Symbols that have been
+// traded and closed out keep a position with zero quantity for their
+// realized P&L.
+type Position struct {
+	Symbol        string          `json:"symbol"`
+	Quantity      int             `json:"quantity"`    // negative for shorts
+	AverageCost   decimal.Decimal `json:"averageCost"` // per share, including commission
+	CostBasis     decimal.Decimal `json:"costBasis"`   // negative for shorts
+	MarketPrice   decimal.Decimal `json:"marketPrice"`
+	MarketValue   decimal.Decimal `json:"marketValue"` // negative for shorts
+	UnrealizedPnL decimal.Decimal `json:"unrealizedPnL"`
+	RealizedPnL   decimal.Decimal `json:"realizedPnL"`
+	Lots          []Lot           `json:"lots"`
+}
+
+// ProfitAndLoss is an account's positions and P&L at current prices
+type ProfitAndLoss struct {
+	CostBasisMethod string          `json:"costBasisMethod"`
+	Positions       []Position      `json:"positions"`
+	RealizedPnL     decimal.Decimal `json:"realizedPnL"`
+	UnrealizedPnL   decimal.Decimal `json:"unrealizedPnL"`
+	TotalPnL        decimal.Decimal `json:"totalPnL"`
+}
+
+// ProfitAndLoss values an account's lots at the live prices, by symbol
+func (s *Storage) ProfitAndLoss(account *UserAccount) ProfitAndLoss {
+	account.mutex.RLock()
+	defer account.mutex.RUnlock()
+
+	report := ProfitAndLoss{CostBasisMethod: s.costBasisMethod(), Positions: make([]Position, 0)}
+	symbols := make(map[string]bool)
+	for symbol := range account.Lots {
+		symbols[symbol] = true
+	}
+	for symbol := range account.RealizedPnL {
+		symbols[symbol] = true
+	}
+
+	for symbol := range symbols {
+		position := Position{
+			Symbol:      symbol,
+			RealizedPnL: account.RealizedPnL[symbol],
+			Lots:        append([]Lot{}, account.Lots[symbol]...),
+		}
+		for _, lot := range position.Lots {
+			position.Quantity += lot.Quantity
+			position.CostBasis += lot.Cost
+		}
+		if position.Quantity != 0 {
+			position.AverageCost = position.CostBasis.Abs().DivInt(abs(position.Quantity))
+			if price, exists := s.GetPrice(symbol); exists {
+				position.MarketPrice = price.Price
+				position.MarketValue = price.Price.MulInt(position.Quantity)
+				position.UnrealizedPnL = position.MarketValue - position.CostBasis
+			}
+		}
+
+		report.RealizedPnL += position.RealizedPnL
+		report.UnrealizedPnL += position.UnrealizedPnL
+		report.Positions = append(report.Positions, position)
+	}
+	sort.Slice(report.Positions, func(i, j int) bool {
+		return report.Positions[i].Symbol < report.Positions[j].Symbol
+	})
+	report.TotalPnL = report.RealizedPnL + report.UnrealizedPnL
+	return report
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
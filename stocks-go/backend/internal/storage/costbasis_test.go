@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fill is a fill to book in a cost basis test
+type fill struct {
+	quantity   int // negative for sells
+	price, fee string
+	at         int // minutes after the start of the test
+}
+
+func TestBookFill(t *testing.T) {
+	start := time.Date(2024, 1, 2, 14, 30, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	lot := func(quantity int, cost string, minutes int) Lot {
+		return Lot{Quantity: quantity, Cost: dec(cost), OpenedAt: at(minutes)}
+	}
+	twoBuysThenSell := []fill{{10, "10", "0", 0}, {10, "12", "0", 1}, {-15, "15", "0", 2}}
+
+	tests := []struct {
+		name     string
+		method   string
+		fills    []fill
+		lots     []Lot
+		realized string
+	}{
+		{
+			name: "fifo closes the oldest lots first", method: CostBasisFIFO, fills: twoBuysThenSell,
+			// 10 @ 10 then 5 of 10 @ 12, for 150 + 75
+			lots: []Lot{lot(5, "60", 1)}, realized: "65",
+		},
+		{
+			name: "lifo closes the newest lots first", method: CostBasisLIFO, fills: twoBuysThenSell,
+			// 10 @ 12 then 5 of 10 @ 10, for 150 + 75
+			lots: []Lot{lot(5, "50", 0)}, realized: "55",
+		},
+		{
+			name: "average merges the lots", method: CostBasisAverage, fills: twoBuysThenSell,
+			// 15 of 20 costing 220 in all, for 225
+			lots: []Lot{lot(5, "55", 0)}, realized: "60",
+		},
+		{
+			name: "commission is part of the cost and comes off the proceeds", method: CostBasisFIFO,
+			fills: []fill{{10, "10", "1", 0}, {-10, "11", "1", 1}},
+			lots:  []Lot{}, realized: "8",
+		},
+		{
+			name: "shorts open negative lots and realize on cover", method: CostBasisFIFO,
+			fills: []fill{{-10, "20", "0", 0}, {4, "15", "0", 1}},
+			lots:  []Lot{lot(-6, "-120", 0)}, realized: "20",
+		},
+		{
+			name: "selling through a long opens a short", method: CostBasisFIFO,
+			fills: []fill{{5, "10", "0", 0}, {-8, "12", "0", 1}},
+			// The 96 of proceeds split 60 to close the long and 36 to open the short
+			lots: []Lot{lot(-3, "-36", 1)}, realized: "10",
+		},
+		{
+			name: "buying through a short opens a long", method: CostBasisLIFO,
+			fills: []fill{{-2, "10", "0", 0}, {-1, "11", "0", 1}, {5, "9", "0", 2}},
+			// Covers 1 @ 11 then 2 @ 10 for 27, and opens 2 @ 9
+			lots: []Lot{lot(2, "18", 2)}, realized: "4",
+		},
+		{
+			name: "partial closes keep the rest of the lot's cost", method: CostBasisFIFO,
+			fills: []fill{{3, "10", "0.01", 0}, {-1, "10", "0", 1}},
+			// 30.01 for 3 shares: a third of it, rounded, goes with the share sold
+			lots: []Lot{lot(2, "20.0067", 0)}, realized: "-0.0033",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := &UserAccount{Username: "alice"}
+			for _, f := range tt.fills {
+				account.bookFill("AAA", f.quantity, dec(f.price), dec(f.fee), tt.method, at(f.at))
+			}
+
+			lots := account.Lots["AAA"]
+			if lots == nil {
+				lots = []Lot{}
+			}
+			if !reflect.DeepEqual(lots, tt.lots) {
+				t.Errorf("lots = %+v, want %+v", lots, tt.lots)
+			}
+			if got := account.RealizedPnL["AAA"]; got != dec(tt.realized) {
+				t.Errorf("realized = %s, want %s", got, tt.realized)
+			}
+		})
+	}
+}
+
+func TestProfitAndLoss(t *testing.T) {
+	s := newTestStorage(t, "alice")
+	s.SetCostBasisMethod(CostBasisLIFO)
+	account := s.GetAccount("alice")
+	now := time.Now()
+	account.bookFill("AAA", 10, dec("90"), dec("0"), CostBasisLIFO, now)
+	account.bookFill("AAA", -4, dec("95"), dec("0"), CostBasisLIFO, now)
+	account.bookFill("OLD", 2, dec("5"), dec("0"), CostBasisLIFO, now)
+	account.bookFill("OLD", -2, dec("4"), dec("0"), CostBasisLIFO, now)
+
+	report := s.ProfitAndLoss(account)
+	want := []Position{
+		{
+			Symbol: "AAA", Quantity: 6, AverageCost: dec("90"), CostBasis: dec("540"),
+			MarketPrice: dec("100"), MarketValue: dec("600"), UnrealizedPnL: dec("60"), RealizedPnL: dec("20"),
+			Lots: []Lot{{Quantity: 6, Cost: dec("540"), OpenedAt: now}},
+		},
+		{Symbol: "OLD", RealizedPnL: dec("-2"), Lots: []Lot{}},
+	}
+	if !reflect.DeepEqual(report.Positions, want) {
+		t.Errorf("positions = %+v, want %+v", report.Positions, want)
+	}
+	if report.CostBasisMethod != CostBasisLIFO || report.RealizedPnL != dec("18") ||
+		report.UnrealizedPnL != dec("60") || report.TotalPnL != dec("78") {
+		t.Errorf("report = %s realized %s, unrealized %s, total %s, want lifo 18, 60, 78",
+			report.CostBasisMethod, report.RealizedPnL, report.UnrealizedPnL, report.TotalPnL)
+	}
+}
@@ -0,0 +1,43 @@
+package storage
+
+import "log"
+
+// UserEvent reports a change to one user's state: an order, when Order is
+// set, or otherwise their account's balances and positions
+type UserEvent struct {
+	Username string
+	Order    *Order // copy of the order as changed
+}
+
+// NotifyUserEvents has storage send an event on events for every change to
+// a user's orders or account. Sends never block, so events are dropped
+// while the channel is full; a nil channel stops the events.
+func (s *Storage) NotifyUserEvents(events chan<- UserEvent) {
+	s.eventsMutex.Lock()
+	defer s.eventsMutex.Unlock()
+	s.events = events
+}
+
+// notify sends event to the channel set by NotifyUserEvents, if any
+func (s *Storage) notify(event UserEvent) {
+	s.eventsMutex.RLock()
+	defer s.eventsMutex.RUnlock()
+	if s.events == nil {
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		log.Printf("Dropped an event for %s: the user event channel is full", event.Username)
+	}
+}
+
+// orderChanged notifies an order's owner that it changed. Callers must
+// hold ordersMutex.
+func (s *Storage) orderChanged(order *Order) {
+	if order.Username == "" {
+		return
+	}
+	changed := *order
+	s.notify(UserEvent{Username: order.Username, Order: &changed})
+}
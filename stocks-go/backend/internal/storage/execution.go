@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"fmt"
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/matching"
+	"time"
+)
+
+// SubmitOrder validates an order and matches it against the symbol's order
+// book in price-time priority. Limit orders sweep resting orders up to their
+// limit price and the remainder rests on the book as "pending". Market
+// orders sweep resting orders priced at or better than the simulated market
+// price, and the simulated market fills whatever is left at that price.
+//
+// Stop and stop-limit orders are armed instead and only submitted, as a
+// market or limit order respectively, once the market reaches their stop price.
+func (s *Storage) SubmitOrder(order *Order) error {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+	defer s.settleGroups()
+
+	if halt, halted := s.halted(order.Symbol); halted {
+		if !s.circuitBreakerPolicy().Queue {
+			return haltError(halt)
+		}
+		s.queue(order)
+		return nil
+	}
+	if order.IsStop() {
+		return s.arm(order)
+	}
+	return s.submit(order)
+}
+
+// checkFunds verifies the account's available (unreserved) balance can
+// cover quantity more of an order. Margin accounts need enough available
+// buying power instead, unless the order only reduces a position.
+func (s *Storage) checkFunds(order *Order, quantity int, price decimal.Decimal) error {
+	account := s.GetAccount(order.Username)
+	if account == nil {
+		return &OrderError{"Account not found"}
+	}
+	cost := s.buyCost(quantity, price)
+
+	account.mutex.RLock()
+	defer account.mutex.RUnlock()
+	if account.IsMargin() {
+		if account.reducesPosition(order.Side, order.Symbol, quantity) {
+			return nil
+		}
+		if s.marginStatus(account).AvailableBuyingPower < cost {
+			return &OrderError{"Insufficient buying power"}
+		}
+		return nil
+	}
+	if order.Side == "buy" && account.AvailableCredits() < cost {
+		return &OrderError{"Insufficient credits"}
+	}
+	if order.Side == "sell" && account.AvailableShares(order.Symbol) < quantity {
+		return &OrderError{"Insufficient stocks to sell"}
+	}
+	return nil
+}
+
+// submit validates, matches and records an order; callers must hold ordersMutex
+func (s *Storage) submit(order *Order) error {
+	stockPrice, exists := s.GetPrice(order.Symbol)
+	if !exists {
+		return &OrderError{"Stock not found"}
+	}
+	marketPrice := stockPrice.Price
+
+	limit := order.Price
+	if order.atMarket() {
+		limit = marketPrice
+	}
+	if err := s.checkFunds(order, order.Remaining(), limit); err != nil {
+		return err
+	}
+
+	order.Status = "pending"
+	book := s.books[order.Symbol]
+
+	// Fill-or-kill limit orders are cancelled outright unless the book can
+	// fill them completely; market orders can always fill from the market
+	if order.TimeInForce == "FOK" && !order.atMarket() &&
+		book.Available(order.Username, order.Side, limit) < order.Remaining() {
+		order.Status = "cancelled"
+		s.recordOrder(order)
+		s.touch(order)
+		return nil
+	}
+
+	book.Match(order.Username, order.Side, order.Remaining(), limit, func(maker *matching.Entry, qty int, price decimal.Decimal) int {
+		makerOrder, ok := s.orderIndex[maker.OrderID]
+		if !ok {
+			return 0
+		}
+		return s.fillResting(makerOrder, order, qty, price)
+	})
+
+	// Market orders fill whatever the book could not at the market price
+	if order.Remaining() > 0 && order.atMarket() {
+		if order.Side == "buy" {
+			s.execute(order, nil, order.Remaining(), marketPrice, "buy")
+		} else {
+			s.execute(nil, order, order.Remaining(), marketPrice, "sell")
+		}
+	}
+
+	// Market orders never rest on the book; an unfilled remainder is cancelled
+	if order.Remaining() > 0 && order.atMarket() {
+		if order.FilledQuantity == 0 {
+			return &OrderError{"Order could not be filled"}
+		}
+		order.Status = "cancelled"
+	}
+
+	// Immediate-or-cancel orders never rest either
+	if order.Remaining() > 0 && !order.atMarket() {
+		if order.TimeInForce == "IOC" || order.TimeInForce == "FOK" {
+			order.Status = "cancelled"
+		} else {
+			s.rest(order, order.Remaining())
+		}
+	}
+	s.recordOrder(order)
+	s.touch(order)
+	return nil
+}
+
+// recordOrder adds an order to storage unless it is already there, as
+// triggered stops are. Callers must hold ordersMutex.
+func (s *Storage) recordOrder(order *Order) {
+	if _, exists := s.orderIndex[order.ID]; !exists {
+		s.addOrder(order)
+	}
+}
+
+// settle transfers shares and credits for a single execution. It settles as
+// much of quantity as both sides' available balances can cover and returns
+// that amount, so reserved funds must be released before settling against them.
+// An empty username denotes the simulated market on that side of the trade.
+// The cash moves, and any commission, are posted to the ledger under tradeID,
+// and each user side's lots are updated. Callers must hold ordersMutex.
+func (s *Storage) settle(tradeID, buyer, seller, symbol string, quantity int, price decimal.Decimal) int {
+	rate := s.feeRate()
+	method := s.costBasisMethod()
+	now := time.Now()
+
+	var buyerAccount, sellerAccount *UserAccount
+	if buyer != "" {
+		if buyerAccount = s.GetAccount(buyer); buyerAccount == nil {
+			return 0
+		}
+		buyerAccount.mutex.Lock()
+		defer buyerAccount.mutex.Unlock()
+	}
+	if seller != "" {
+		if sellerAccount = s.GetAccount(seller); sellerAccount == nil {
+			return 0
+		}
+		sellerAccount.mutex.Lock()
+		defer sellerAccount.mutex.Unlock()
+	}
+
+	// Margin accounts were checked against buying power when the order was
+	// placed and may borrow cash or go short here
+	if buyerAccount != nil && !buyerAccount.IsMargin() {
+		available := buyerAccount.AvailableCredits()
+		if affordable := available.Quo(price); affordable < quantity {
+			quantity = affordable
+		}
+		for quantity > 0 && withFee(price.MulInt(quantity), rate) > available {
+			quantity--
+		}
+	}
+	if sellerAccount != nil && !sellerAccount.IsMargin() && sellerAccount.AvailableShares(symbol) < quantity {
+		quantity = sellerAccount.AvailableShares(symbol)
+	}
+	if quantity <= 0 {
+		return 0
+	}
+
+	total := price.MulInt(quantity)
+	fee := total.Mul(rate)
+	s.post(LedgerEntry{
+		Type:      "trade",
+		Reference: tradeID,
+		Memo:      fmt.Sprintf("%d %s @ %s", quantity, symbol, price),
+		Postings: []Posting{
+			{Account: cashAccount(buyer), Amount: -total},
+			{Account: cashAccount(seller), Amount: total},
+		},
+	}, buyerAccount, sellerAccount)
+
+	if buyerAccount != nil {
+		s.chargeFee(buyerAccount, tradeID, fee)
+		buyerAccount.bookFill(symbol, quantity, price, fee, method, now)
+		s.accountChanged(buyerAccount)
+		buyerAccount.Portfolio[symbol] += quantity
+		// Covering a short can also flatten the position
+		if buyerAccount.Portfolio[symbol] == 0 {
+			delete(buyerAccount.Portfolio, symbol)
+		}
+	}
+	if sellerAccount != nil {
+		s.chargeFee(sellerAccount, tradeID, fee)
+		sellerAccount.bookFill(symbol, -quantity, price, fee, method, now)
+		s.accountChanged(sellerAccount)
+		sellerAccount.Portfolio[symbol] -= quantity
+		// Remove from portfolio if quantity becomes 0
+		if sellerAccount.Portfolio[symbol] == 0 {
+			delete(sellerAccount.Portfolio, symbol)
+		}
+	}
+	return quantity
+}
+
+// chargeFee posts a trade's commission for one side; callers must hold the account mutex
+func (s *Storage) chargeFee(account *UserAccount, tradeID string, fee decimal.Decimal) {
+	if fee <= 0 {
+		return
+	}
+	s.post(LedgerEntry{
+		Type:      "fee",
+		Reference: tradeID,
+		Memo:      "Commission",
+		Postings: []Posting{
+			{Account: cashAccount(account.Username), Amount: -fee},
+			{Account: houseFees, Amount: fee},
+		},
+	}, account)
+}
+
+// updateOrderStatuses triggers stops reached by the new market price and then
+// fills resting orders that cross it. Resting orders are visited in
+// price-time priority and executed at the market price.
+func (s *Storage) updateOrderStatuses(symbol string, currentPrice decimal.Decimal) {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+
+	book, exists := s.books[symbol]
+	if !exists {
+		return
+	}
+
+	defer s.settleGroups()
+
+	s.triggerStops(symbol, currentPrice)
+
+	for _, entry := range book.Crossed(currentPrice) {
+		order, ok := s.orderIndex[entry.OrderID]
+		if !ok {
+			continue
+		}
+		if filled := s.fillResting(order, nil, entry.Remaining, currentPrice); filled > 0 {
+			book.Reduce(entry.OrderID, filled)
+		}
+	}
+}
+
+// OrderBookDepth returns the aggregated bid and ask levels for a symbol
+func (s *Storage) OrderBookDepth(symbol string, levels int) (bids, asks []matching.Level, ok bool) {
+	s.ordersMutex.RLock()
+	defer s.ordersMutex.RUnlock()
+
+	book, exists := s.books[symbol]
+	if !exists {
+		return nil, nil, false
+	}
+	bids, asks = book.Depth(levels)
+	return bids, asks, true
+}
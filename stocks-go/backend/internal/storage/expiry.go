@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"log"
+	"time"
+)
+
+// EndOfDay returns when a DAY order placed at t expires: the end of the
+// trading day, which is midnight in the server's time zone
+func EndOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, t.Location())
+}
+
+// ExpireOrders expires every open order whose ExpiresAt has passed,
+// releasing anything it reserved, and returns the expired orders
+func (s *Storage) ExpireOrders(now time.Time) []Order {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+
+	expired := make([]Order, 0)
+	for _, order := range s.orders {
+		if !order.IsOpen() || order.ExpiresAt == nil || now.Before(*order.ExpiresAt) {
+			continue
+		}
+
+		s.withdraw(order, "expired", now)
+		s.touch(order)
+		expired = append(expired, *order)
+	}
+	s.settleGroups()
+	return expired
+}
+
+// ExpirySweeper periodically expires DAY and GTD orders
+type ExpirySweeper struct {
+	storage Store
+	ticker  *time.Ticker
+	done    chan struct{}
+}
+
+// NewExpirySweeper creates a sweeper that checks for expired orders every interval
+func NewExpirySweeper(store Store, interval time.Duration) *ExpirySweeper {
+	return &ExpirySweeper{
+		storage: store,
+		ticker:  time.NewTicker(interval),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start begins sweeping in the background
+func (e *ExpirySweeper) Start() {
+	go func() {
+		for {
+			select {
+			case now := <-e.ticker.C:
+				if expired := e.storage.ExpireOrders(now); len(expired) > 0 {
+					log.Printf("Expired %d orders", len(expired))
+				}
+			case <-e.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the sweeper
+func (e *ExpirySweeper) Stop() {
+	e.ticker.Stop()
+	close(e.done)
+}
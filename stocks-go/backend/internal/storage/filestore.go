@@ -0,0 +1,744 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/history"
+	"stocks-backend/internal/matching"
+	"sync"
+	"time"
+)
+
+// snapshotEvery is how many journal records are written between snapshots
+const snapshotEvery = 1000
+
+// snapshotInterval is the longest price ticks go without a snapshot, and so
+// the most price history a crash loses
+const snapshotInterval = 5 * time.Minute
+
+// FileStore is a Store that survives restarts and crashes. It keeps the
+// state in memory like Storage, and after every change appends whatever the
+// change modified to a journal file as one JSON record, synced to disk
+// before the change returns. Every snapshotEvery records the whole state is
+// written to a snapshot and the journal starts over. Opening a FileStore
+// loads the snapshot and replays the journal on top of it.
+//
+// Price ticks are not journaled on their own: new prices are written with
+// the next record journaled for an order, account or other change, and the
+// price history only with snapshots, taken at least every snapshotInterval
+// while prices tick. Both are regenerated by the price feed after a crash.
+type FileStore struct {
+	*Storage
+	mutex      sync.Mutex // serializes changes so each is journaled whole
+	dir        string
+	journal    *os.File
+	records    int       // journal records written since the last snapshot
+	snapshotAt time.Time // when the last snapshot was written
+
+	// What has been journaled, to work out what the next change modified.
+	// Closed orders never change again, so only open and new orders are
+	// compared; accounts are marked by the code that changes them.
+	journaledOrders int               // len(orders)
+	journaledTrades int               // len(trades)
+	journaledLedger int               // len(ledger)
+	openOrders      map[string][]byte // open order ID -> last journaled record
+	journaledPrices map[string][]byte // symbol -> last journaled record
+
+	journaledInstruments map[string][]byte // symbol -> last journaled record
+	journaledActions     map[string][]byte // action ID -> last journaled record
+}
+
+// record is one journal entry, or a whole snapshot: the latest state of
+// everything in it
+type record struct {
+	Accounts []accountRecord `json:"accounts,omitempty"`
+	Orders   []orderRecord   `json:"orders,omitempty"`
+	Trades   []tradeRecord   `json:"trades,omitempty"`
+	Groups   []OrderGroup    `json:"groups,omitempty"`
+	Prices   []StockPrice    `json:"prices,omitempty"`
+	Ledger   []LedgerEntry   `json:"ledger,omitempty"`
+
+	Instruments []Instrument      `json:"instruments,omitempty"`
+	Delisted    []string          `json:"delisted,omitempty"` // symbols delisted since the last record
+	Actions     []CorporateAction `json:"actions,omitempty"`
+
+	History []historyRecord `json:"history,omitempty"` // snapshots only: the whole price history
+}
+
+func (r *record) empty() bool {
+	return len(r.Accounts)+len(r.Orders)+len(r.Trades)+len(r.Groups)+len(r.Prices)+len(r.Ledger)+
+		len(r.Instruments)+len(r.Delisted)+len(r.Actions)+len(r.History) == 0
+}
+
+// accountRecord is a UserAccount including its password hash
+type accountRecord struct {
+	Username        string          `json:"username"`
+	PasswordHash    string          `json:"passwordHash"`
+	AccountType     string          `json:"accountType"`
+	Credits         decimal.Decimal `json:"credits"`
+	Portfolio       map[string]int  `json:"portfolio"`
+	ReservedCredits decimal.Decimal `json:"reservedCredits"`
+	ReservedShares  map[string]int  `json:"reservedShares"`
+	MarginCall      *time.Time      `json:"marginCall,omitempty"`
+
+	Lots        map[string][]Lot           `json:"lots,omitempty"`
+	RealizedPnL map[string]decimal.Decimal `json:"realizedPnL,omitempty"`
+}
+
+// orderRecord is an Order including what it holds and its queue position
+type orderRecord struct {
+	Order
+	Hold string `json:"hold,omitempty"`
+	Seq  uint64 `json:"seq,omitempty"`
+}
+
+// tradeRecord is a Trade including the accounts on either side
+type tradeRecord struct {
+	Trade
+	Buyer  string `json:"buyer,omitempty"`
+	Seller string `json:"seller,omitempty"`
+}
+
+// historyRecord is the price history of one symbol; see history.Store.Levels
+type historyRecord struct {
+	Symbol string            `json:"symbol"`
+	Levels [][]history.Point `json:"levels"`
+}
+
+// OpenFileStore opens the FileStore kept in dir, creating it if needed, and
+// restores the state it holds
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f := &FileStore{
+		Storage:              newStorage(),
+		dir:                  dir,
+		openOrders:           make(map[string][]byte),
+		journaledPrices:      make(map[string][]byte),
+		journaledInstruments: make(map[string][]byte),
+		journaledActions:     make(map[string][]byte),
+	}
+	seen := make(map[string]bool) // trade and ledger entry IDs
+
+	snapshot, err := os.ReadFile(filepath.Join(dir, "snapshot.json"))
+	if err == nil {
+		var rec record
+		if err := json.Unmarshal(snapshot, &rec); err != nil {
+			return nil, fmt.Errorf("reading snapshot: %w", err)
+		}
+		f.apply(&rec, seen)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f.journal, err = os.OpenFile(filepath.Join(dir, "journal.log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.replay(seen); err != nil {
+		f.journal.Close()
+		return nil, err
+	}
+	f.rebuild()
+	f.Storage.changedAccounts = make(map[string]bool)
+
+	// Start over from a fresh snapshot and an empty journal
+	if err := f.snapshot(); err != nil {
+		f.journal.Close()
+		return nil, err
+	}
+	log.Printf("Storage restored from %s: %d accounts, %d orders, %d trades",
+		dir, len(f.Storage.accounts), len(f.Storage.orders), len(f.Storage.trades))
+	if report := f.CheckLedger(); !report.Balanced {
+		log.Printf("Warning: restored ledger does not balance: %+v", report)
+	}
+	return f, nil
+}
+
+// replay applies every journal record in order. A final record without its
+// trailing newline was cut short by a crash before it was acknowledged, so
+// it is dropped.
+func (f *FileStore) replay(seen map[string]bool) error {
+	reader := bufio.NewReader(f.journal)
+	offset := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("Dropping incomplete journal record at offset %d", offset)
+				return f.journal.Truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("reading journal record at offset %d: %w", offset, err)
+		}
+		f.apply(&rec, seen)
+		offset += int64(len(line))
+	}
+}
+
+// apply loads a record into storage while the store is being opened.
+// Records hold whole entities, so applying one twice is harmless.
+func (f *FileStore) apply(rec *record, seen map[string]bool) {
+	s := f.Storage
+	for _, a := range rec.Accounts {
+		account, exists := s.accounts[a.Username]
+		if !exists {
+			account = &UserAccount{Username: a.Username}
+			s.accounts[a.Username] = account
+		}
+		account.PasswordHash = a.PasswordHash
+		account.AccountType = a.AccountType
+		account.Credits = a.Credits
+		account.Portfolio = a.Portfolio
+		account.ReservedCredits = a.ReservedCredits
+		account.ReservedShares = a.ReservedShares
+		account.MarginCall = a.MarginCall
+		account.Lots = a.Lots
+		account.RealizedPnL = a.RealizedPnL
+		if account.Portfolio == nil {
+			account.Portfolio = make(map[string]int)
+		}
+		if account.ReservedShares == nil {
+			account.ReservedShares = make(map[string]int)
+		}
+		if account.Lots == nil {
+			account.Lots = make(map[string][]Lot)
+		}
+		if account.RealizedPnL == nil {
+			account.RealizedPnL = make(map[string]decimal.Decimal)
+		}
+	}
+	for _, o := range rec.Orders {
+		order, exists := s.orderIndex[o.ID]
+		if !exists {
+			order = &Order{}
+		}
+		*order = o.Order
+		order.hold, order.seq = o.Hold, o.Seq
+		if !exists {
+			s.addOrder(order)
+		}
+	}
+	for _, t := range rec.Trades {
+		if seen[t.ID] {
+			continue
+		}
+		seen[t.ID] = true
+		trade := t.Trade
+		trade.Buyer, trade.Seller = t.Buyer, t.Seller
+		s.trades = append(s.trades, trade)
+	}
+	for _, entry := range rec.Ledger {
+		if !seen[entry.ID] {
+			seen[entry.ID] = true
+			s.ledger = append(s.ledger, entry)
+		}
+	}
+	for _, group := range rec.Groups {
+		group := group
+		s.groups[group.ID] = &group
+	}
+	for _, action := range rec.Actions {
+		action := action
+		s.actions[action.ID] = &action
+	}
+	for _, instrument := range rec.Instruments {
+		instrument := instrument
+		s.instruments[instrument.Symbol] = &instrument
+	}
+	for _, price := range rec.Prices {
+		price := price
+		s.prices[price.Symbol] = &price
+		if _, exists := s.books[price.Symbol]; !exists {
+			s.books[price.Symbol] = matching.NewOrderBook(price.Symbol)
+		}
+	}
+	for _, symbol := range rec.Delisted {
+		delete(s.instruments, symbol)
+		delete(s.prices, symbol)
+		delete(s.books, symbol)
+		delete(s.candles, symbol)
+	}
+	for _, h := range rec.History {
+		s.ticks.Restore(h.Symbol, h.Levels)
+	}
+}
+
+// rebuild puts restored orders back on their books and armed stops back on
+// watch, in the order they originally joined them
+func (f *FileStore) rebuild() {
+	s := f.Storage
+	queued := make([]*Order, 0)
+	for _, order := range s.orders {
+		if order.seq > s.sequence {
+			s.sequence = order.seq
+		}
+		switch order.Status {
+		case "armed", "pending", "partially_filled":
+			queued = append(queued, order)
+		case "queued":
+			// Halts end with a restart, so these are submitted by the
+			// next CheckHalts
+			s.queued[order.Symbol] = append(s.queued[order.Symbol], order)
+		}
+	}
+	sort.Slice(queued, func(i, j int) bool { return queued[i].seq < queued[j].seq })
+
+	for _, order := range queued {
+		if order.Status == "armed" {
+			s.stops[order.Symbol] = append(s.stops[order.Symbol], order)
+			continue
+		}
+		book, exists := s.books[order.Symbol]
+		if !exists {
+			book = matching.NewOrderBook(order.Symbol)
+			s.books[order.Symbol] = book
+		}
+		book.Add(order.ID, order.Username, order.Side, order.Price, order.Remaining())
+	}
+}
+
+// collect gathers everything but prices modified since the last call, or
+// the whole state when full is set, and notes it as journaled. Callers must
+// hold f.mutex.
+func (f *FileStore) collect(full bool) record {
+	s := f.Storage
+	var rec record
+
+	s.ordersMutex.RLock()
+	candidates := s.orders
+	if full {
+		f.openOrders = make(map[string][]byte)
+	} else {
+		candidates = make([]*Order, 0, len(f.openOrders))
+		for id := range f.openOrders {
+			candidates = append(candidates, s.orderIndex[id])
+		}
+		candidates = append(candidates, s.orders[f.journaledOrders:]...)
+	}
+	groupIDs := make(map[string]bool)
+	for _, order := range candidates {
+		r := orderRecord{Order: *order, Hold: order.hold, Seq: order.seq}
+		data, err := json.Marshal(r)
+		if err != nil {
+			log.Printf("Error encoding order %s: %v", order.ID, err)
+			continue
+		}
+		if !full && bytes.Equal(f.openOrders[order.ID], data) {
+			continue
+		}
+
+		rec.Orders = append(rec.Orders, r)
+		if order.GroupID != "" {
+			groupIDs[order.GroupID] = true
+		}
+		if order.IsOpen() {
+			f.openOrders[order.ID] = data
+		} else {
+			delete(f.openOrders, order.ID)
+		}
+	}
+
+	trades := s.trades[f.journaledTrades:]
+	if full {
+		trades = s.trades
+	}
+	for _, trade := range trades {
+		rec.Trades = append(rec.Trades, tradeRecord{Trade: trade, Buyer: trade.Buyer, Seller: trade.Seller})
+	}
+
+	for id, group := range s.groups {
+		if full || groupIDs[id] {
+			g := *group
+			g.OrderIDs = append([]string(nil), group.OrderIDs...)
+			rec.Groups = append(rec.Groups, g)
+		}
+	}
+	if full {
+		f.journaledActions = make(map[string][]byte)
+	}
+	for id, action := range s.actions {
+		data, err := json.Marshal(action)
+		if err != nil {
+			log.Printf("Error encoding corporate action %s: %v", id, err)
+			continue
+		}
+		if !full && bytes.Equal(f.journaledActions[id], data) {
+			continue
+		}
+		rec.Actions = append(rec.Actions, *action)
+		f.journaledActions[id] = data
+	}
+	f.journaledOrders, f.journaledTrades = len(s.orders), len(s.trades)
+	s.ordersMutex.RUnlock()
+
+	s.ledgerMutex.RLock()
+	entries := s.ledger[f.journaledLedger:]
+	if full {
+		entries = s.ledger
+	}
+	rec.Ledger = append(rec.Ledger, entries...)
+	f.journaledLedger = len(s.ledger)
+	s.ledgerMutex.RUnlock()
+
+	usernames := make([]string, 0, len(s.changedAccounts))
+	if full {
+		s.accountsMutex.RLock()
+		for username := range s.accounts {
+			usernames = append(usernames, username)
+		}
+		s.accountsMutex.RUnlock()
+	} else {
+		for username := range s.changedAccounts {
+			usernames = append(usernames, username)
+		}
+	}
+	clear(s.changedAccounts)
+	for _, username := range usernames {
+		if account := s.GetAccount(username); account != nil {
+			rec.Accounts = append(rec.Accounts, account.record())
+		}
+	}
+
+	if full {
+		f.journaledInstruments = make(map[string][]byte)
+	}
+	listed := make(map[string]bool)
+	for _, instrument := range s.Instruments() {
+		listed[instrument.Symbol] = true
+		data, err := json.Marshal(instrument)
+		if err != nil {
+			log.Printf("Error encoding instrument %s: %v", instrument.Symbol, err)
+			continue
+		}
+		if !full && bytes.Equal(f.journaledInstruments[instrument.Symbol], data) {
+			continue
+		}
+		rec.Instruments = append(rec.Instruments, instrument)
+		f.journaledInstruments[instrument.Symbol] = data
+	}
+	for symbol := range f.journaledInstruments {
+		if !listed[symbol] {
+			rec.Delisted = append(rec.Delisted, symbol)
+			delete(f.journaledInstruments, symbol)
+			delete(f.journaledPrices, symbol)
+		}
+	}
+
+	if full {
+		f.collectPrices(&rec, true)
+		for _, symbol := range s.ticks.Symbols() {
+			rec.History = append(rec.History, historyRecord{Symbol: symbol, Levels: s.ticks.Levels(symbol)})
+		}
+	}
+	return rec
+}
+
+// collectPrices adds the prices changed since the last call, or all of them
+// when full is set, to rec and notes them as journaled. Callers must hold
+// f.mutex.
+func (f *FileStore) collectPrices(rec *record, full bool) {
+	s := f.Storage
+	if full {
+		f.journaledPrices = make(map[string][]byte)
+	}
+	for _, price := range s.GetAllPrices() {
+		data, err := json.Marshal(price)
+		if err != nil {
+			log.Printf("Error encoding price of %s: %v", price.Symbol, err)
+			continue
+		}
+		if !full && bytes.Equal(f.journaledPrices[price.Symbol], data) {
+			continue
+		}
+		rec.Prices = append(rec.Prices, price)
+		f.journaledPrices[price.Symbol] = data
+	}
+}
+
+// record copies an account for the journal
+func (a *UserAccount) record() accountRecord {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	r := accountRecord{
+		Username:        a.Username,
+		PasswordHash:    a.PasswordHash,
+		AccountType:     a.AccountType,
+		Credits:         a.Credits,
+		Portfolio:       make(map[string]int, len(a.Portfolio)),
+		ReservedCredits: a.ReservedCredits,
+		ReservedShares:  make(map[string]int, len(a.ReservedShares)),
+		MarginCall:      a.MarginCall,
+		Lots:            make(map[string][]Lot, len(a.Lots)),
+		RealizedPnL:     make(map[string]decimal.Decimal, len(a.RealizedPnL)),
+	}
+	for symbol, quantity := range a.Portfolio {
+		r.Portfolio[symbol] = quantity
+	}
+	for symbol, quantity := range a.ReservedShares {
+		r.ReservedShares[symbol] = quantity
+	}
+	for symbol, lots := range a.Lots {
+		r.Lots[symbol] = append([]Lot{}, lots...)
+	}
+	for symbol, pnl := range a.RealizedPnL {
+		r.RealizedPnL[symbol] = pnl
+	}
+	return r
+}
+
+// commit journals what the change that just ran modified, with any prices
+// changed since the last record, and takes a snapshot once enough records
+// have built up. A change to prices alone is not journaled. Callers must
+// hold f.mutex.
+func (f *FileStore) commit() {
+	rec := f.collect(false)
+	if rec.empty() {
+		return
+	}
+	f.collectPrices(&rec, false)
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("Error encoding journal record: %v", err)
+		return
+	}
+	if _, err := f.journal.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing journal: %v", err)
+		return
+	}
+	if err := f.journal.Sync(); err != nil {
+		log.Printf("Error syncing journal: %v", err)
+	}
+
+	f.records++
+	if f.records >= snapshotEvery {
+		if err := f.snapshot(); err != nil {
+			log.Printf("Error writing snapshot: %v", err)
+		}
+	}
+}
+
+// snapshot atomically replaces the snapshot with the whole current state
+// and empties the journal. Callers must hold f.mutex.
+func (f *FileStore) snapshot() error {
+	rec := f.collect(true)
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(f.dir, "snapshot.json")
+	if err := writeFileSync(path+".tmp", data); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	// Replaying records already in the snapshot is harmless, so a crash
+	// before the journal is emptied loses nothing
+	if err := f.journal.Truncate(0); err != nil {
+		return err
+	}
+	f.records = 0
+	f.snapshotAt = time.Now()
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Close writes a final snapshot and closes the journal
+func (f *FileStore) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	err := f.snapshot()
+	if closeErr := f.journal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Every change goes through the methods below, which journal it before
+// returning.
+
+// CreateAccount creates an account and journals it
+func (f *FileStore) CreateAccount(username, password, accountType string) *UserAccount {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.CreateAccount(username, password, accountType)
+}
+
+// AddOrder records an order without matching it and journals it
+func (f *FileStore) AddOrder(order Order) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	f.Storage.AddOrder(order)
+}
+
+// SubmitOrder submits an order and journals its effects
+func (f *FileStore) SubmitOrder(order *Order) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.SubmitOrder(order)
+}
+
+// SubmitBracket submits a bracket order and journals its effects
+func (f *FileStore) SubmitBracket(groupID string, entry, takeProfit, stopLoss *Order) ([]Order, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.SubmitBracket(groupID, entry, takeProfit, stopLoss)
+}
+
+// SubmitOCO submits a one-cancels-other pair and journals its effects
+func (f *FileStore) SubmitOCO(groupID string, takeProfit, stopLoss *Order) ([]Order, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.SubmitOCO(groupID, takeProfit, stopLoss)
+}
+
+// CancelOrder cancels an order and journals its effects
+func (f *FileStore) CancelOrder(username, orderID string) (Order, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.CancelOrder(username, orderID)
+}
+
+// AmendOrder amends an order and journals its effects
+func (f *FileStore) AmendOrder(username, orderID string, price decimal.Decimal, quantity int) (Order, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.AmendOrder(username, orderID, price, quantity)
+}
+
+// ExpireOrders expires orders and journals its effects
+func (f *FileStore) ExpireOrders(now time.Time) []Order {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.ExpireOrders(now)
+}
+
+// CheckHalts lifts expired halts and journals their effects
+func (f *FileStore) CheckHalts(now time.Time) []HaltEvent {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.CheckHalts(now)
+}
+
+// CheckMargins runs the margin checks and journals their effects
+func (f *FileStore) CheckMargins() []MarginEvent {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.CheckMargins()
+}
+
+// UpdatePrice updates a stock price and journals the orders it filled or
+// triggered, if any. The price itself waits for the next record or
+// snapshot.
+func (f *FileStore) UpdatePrice(symbol string, newPrice decimal.Decimal, change float64, at time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.Storage.UpdatePrice(symbol, newPrice, change, at)
+	f.commit()
+	if time.Since(f.snapshotAt) >= snapshotInterval {
+		if err := f.snapshot(); err != nil {
+			log.Printf("Error writing snapshot: %v", err)
+		}
+	}
+}
+
+// ListInstrument lists an instrument and journals it
+func (f *FileStore) ListInstrument(instrument Instrument) (Instrument, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.ListInstrument(instrument)
+}
+
+// DelistInstrument delists an instrument and journals its effects
+func (f *FileStore) DelistInstrument(symbol string, price decimal.Decimal) (Delisting, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.DelistInstrument(symbol, price)
+}
+
+// ScheduleCorporateAction schedules a corporate action and journals it
+func (f *FileStore) ScheduleCorporateAction(action CorporateAction) (CorporateAction, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.ScheduleCorporateAction(action)
+}
+
+// CancelCorporateAction cancels a corporate action and journals it
+func (f *FileStore) CancelCorporateAction(id string) (CorporateAction, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.commit()
+	return f.Storage.CancelCorporateAction(id)
+}
+
+// ApplyCorporateActions applies the corporate actions that are due and
+// journals their effects. Splits rewrite the price history, which the
+// journal does not hold, so they are saved with a fresh snapshot instead.
+func (f *FileStore) ApplyCorporateActions(now time.Time) []CorporateAction {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	actions := f.Storage.ApplyCorporateActions(now)
+	for _, action := range actions {
+		if action.Type == "split" && action.Status == "applied" {
+			if err := f.snapshot(); err != nil {
+				log.Printf("Error writing snapshot: %v", err)
+			}
+			return actions
+		}
+	}
+	f.commit()
+	return actions
+}
@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"log"
+	"stocks-backend/internal/decimal"
+	"time"
+)
+
+// OrderGroup links orders that must be managed together.
+//
+// A "bracket" group has an entry order plus a take-profit limit and a
+// stop-loss stop on the opposite side. The exits are "held" until the entry
+// stops trading and are then activated for the quantity the entry filled.
+// An "oco" group is just the two exits, active straight away. In both, the
+// first exit to fill, trigger, be cancelled or expire cancels the other.
+type OrderGroup struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"` // "bracket" or "oco"
+	Username  string    `json:"username"`
+	Symbol    string    `json:"symbol"`
+	OrderIDs  []string  `json:"orderIds"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// touch notes that an order's state changed: its owner is notified, and a
+// grouped order is queued for settleGroups. Callers must hold ordersMutex.
+func (s *Storage) touch(order *Order) {
+	s.orderChanged(order)
+	if order.GroupID != "" {
+		s.groupQueue = append(s.groupQueue, order)
+	}
+}
+
+// settleGroups applies group rules to every queued order. It runs after the
+// book has been updated, never from inside a match, since activating or
+// cancelling a leg can itself trade. Callers must hold ordersMutex.
+func (s *Storage) settleGroups() {
+	for len(s.groupQueue) > 0 {
+		order := s.groupQueue[0]
+		s.groupQueue = s.groupQueue[1:]
+
+		group, ok := s.groups[order.GroupID]
+		if !ok || order.Status == "replaced" {
+			continue
+		}
+
+		if order.GroupRole == "entry" {
+			// Exits wait until the entry can no longer trade
+			if !order.IsOpen() {
+				s.activateExits(group)
+			}
+			continue
+		}
+		if order.FilledQuantity > 0 || order.Status == "cancelled" || order.Status == "expired" {
+			s.cancelSiblings(order)
+		}
+	}
+}
+
+// activateExits releases a bracket's held exits for whatever the entry
+// filled, or cancels them if it filled nothing. Callers must hold ordersMutex.
+func (s *Storage) activateExits(group *OrderGroup) {
+	filled := 0
+	for _, id := range group.OrderIDs {
+		if order, ok := s.orderIndex[id]; ok && order.GroupRole == "entry" {
+			filled += order.FilledQuantity
+		}
+	}
+
+	now := time.Now()
+	for _, id := range group.OrderIDs {
+		order, ok := s.orderIndex[id]
+		if !ok || order.Status != "held" {
+			continue
+		}
+		if filled == 0 {
+			s.withdraw(order, "cancelled", now)
+			continue
+		}
+
+		order.Quantity = filled
+		order.UpdatedAt = &now
+		if order.IsStop() {
+			s.armUnchecked(order)
+			continue
+		}
+		if err := s.submit(order); err != nil {
+			log.Printf("Bracket exit %s for %s rejected on activation: %v", order.ID, order.Username, err)
+			order.Status = "rejected"
+			s.orderChanged(order)
+		}
+	}
+}
+
+// cancelSiblings cancels the other open exits in an order's group.
+// Callers must hold ordersMutex.
+func (s *Storage) cancelSiblings(order *Order) {
+	group, ok := s.groups[order.GroupID]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	for _, id := range group.OrderIDs {
+		sibling, ok := s.orderIndex[id]
+		if !ok || sibling == order || sibling.GroupRole == "entry" || !sibling.IsOpen() {
+			continue
+		}
+		s.withdraw(sibling, "cancelled", now)
+	}
+}
+
+// newGroup registers a group over orders; callers must hold ordersMutex
+func (s *Storage) newGroup(id, groupType string, orders ...*Order) *OrderGroup {
+	group := &OrderGroup{
+		ID:        id,
+		Type:      groupType,
+		Username:  orders[0].Username,
+		Symbol:    orders[0].Symbol,
+		OrderIDs:  make([]string, 0, len(orders)),
+		CreatedAt: time.Now(),
+	}
+	for _, order := range orders {
+		order.GroupID = id
+		group.OrderIDs = append(group.OrderIDs, order.ID)
+	}
+	s.groups[id] = group
+	return group
+}
+
+// checkExits validates a take-profit limit and stop-loss stop that close a
+// position on side, relative to reference (the entry or market price)
+func checkExits(side string, reference decimal.Decimal, takeProfit, stopLoss *Order) error {
+	if takeProfit.Side != side || stopLoss.Side != side {
+		return &OrderError{"Take-profit and stop-loss must be on the same side"}
+	}
+	if takeProfit.OrderType != "limit" || !stopLoss.IsStop() {
+		return &OrderError{"Take-profit must be a limit order and stop-loss a stop order"}
+	}
+	if side == "sell" && !(stopLoss.StopPrice < reference && reference < takeProfit.Price) {
+		return &OrderError{"A sell exit needs stop-loss < price < take-profit"}
+	}
+	if side == "buy" && !(takeProfit.Price < reference && reference < stopLoss.StopPrice) {
+		return &OrderError{"A buy exit needs take-profit < price < stop-loss"}
+	}
+	return nil
+}
+
+// SubmitBracket submits an entry order together with held take-profit and
+// stop-loss exits on the opposite side. Either the whole group is created
+// or, if the entry is rejected, nothing is. It returns the group's orders.
+func (s *Storage) SubmitBracket(groupID string, entry, takeProfit, stopLoss *Order) ([]Order, error) {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+
+	stockPrice, exists := s.GetPrice(entry.Symbol)
+	if !exists {
+		return nil, &OrderError{"Stock not found"}
+	}
+	if halt, halted := s.halted(entry.Symbol); halted {
+		return nil, haltError(halt)
+	}
+	reference := entry.Price
+	if entry.atMarket() {
+		reference = stockPrice.Price
+	}
+	if err := checkExits(takeProfit.Side, reference, takeProfit, stopLoss); err != nil {
+		return nil, err
+	}
+	if takeProfit.Side == entry.Side {
+		return nil, &OrderError{"Exits must be on the opposite side to the entry"}
+	}
+
+	entry.GroupRole = "entry"
+	takeProfit.GroupRole = "take_profit"
+	stopLoss.GroupRole = "stop_loss"
+	group := s.newGroup(groupID, "bracket", entry, takeProfit, stopLoss)
+
+	var err error
+	if entry.IsStop() {
+		err = s.arm(entry)
+	} else {
+		err = s.submit(entry)
+	}
+	if err != nil {
+		delete(s.groups, group.ID)
+		return nil, err
+	}
+
+	for _, exit := range []*Order{takeProfit, stopLoss} {
+		exit.Status = "held"
+		s.addOrder(exit)
+		s.orderChanged(exit)
+	}
+	// The entry may already have finished trading
+	s.touch(entry)
+	s.settleGroups()
+
+	return s.groupOrders(group), nil
+}
+
+// SubmitOCO submits a take-profit limit and a stop-loss stop as a
+// one-cancels-other pair. Both close the same position, so funds are
+// checked and reserved once, by the take-profit.
+func (s *Storage) SubmitOCO(groupID string, takeProfit, stopLoss *Order) ([]Order, error) {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+
+	stockPrice, exists := s.GetPrice(takeProfit.Symbol)
+	if !exists {
+		return nil, &OrderError{"Stock not found"}
+	}
+	if halt, halted := s.halted(takeProfit.Symbol); halted {
+		return nil, haltError(halt)
+	}
+	if takeProfit.Symbol != stopLoss.Symbol || takeProfit.Quantity != stopLoss.Quantity {
+		return nil, &OrderError{"Both legs must be for the same symbol and quantity"}
+	}
+	if err := checkExits(takeProfit.Side, stockPrice.Price, takeProfit, stopLoss); err != nil {
+		return nil, err
+	}
+
+	takeProfit.GroupRole = "take_profit"
+	stopLoss.GroupRole = "stop_loss"
+	group := s.newGroup(groupID, "oco", takeProfit, stopLoss)
+
+	if err := s.submit(takeProfit); err != nil {
+		delete(s.groups, group.ID)
+		return nil, err
+	}
+	s.armUnchecked(stopLoss)
+	s.settleGroups()
+
+	return s.groupOrders(group), nil
+}
+
+// groupOrders returns copies of a group's orders; callers must hold ordersMutex
+func (s *Storage) groupOrders(group *OrderGroup) []Order {
+	orders := make([]Order, 0, len(group.OrderIDs))
+	for _, id := range group.OrderIDs {
+		if order, ok := s.orderIndex[id]; ok {
+			orders = append(orders, *order)
+		}
+	}
+	return orders
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"stocks-backend/internal/decimal"
+	"time"
+)
+
+// CircuitBreakerPolicy sets the limit-up/limit-down bands around each
+// symbol's reference price, the average price over the preceding Window.
+// A tick outside the band is held at the band and halts the symbol for
+// Cooldown.
+type CircuitBreakerPolicy struct {
+	Band     decimal.Decimal `json:"band"`     // allowed move from the reference, as a fraction; 0 disables halts
+	Window   time.Duration   `json:"window"`   // period the reference price is averaged over
+	Cooldown time.Duration   `json:"cooldown"` // how long a halt lasts
+	Queue    bool            `json:"queue"`    // queue new orders while halted instead of rejecting them
+}
+
+// DefaultCircuitBreakerPolicy halts a symbol for 5 minutes when it moves
+// 10% away from its 5 minute average
+var DefaultCircuitBreakerPolicy = CircuitBreakerPolicy{
+	Band:     decimal.New(1, 1),
+	Window:   5 * time.Minute,
+	Cooldown: 5 * time.Minute,
+}
+
+// Halt is a trading halt on one symbol
+type Halt struct {
+	Symbol    string          `json:"symbol"`
+	Reference decimal.Decimal `json:"reference"` // average price the bands were set around
+	Lower     decimal.Decimal `json:"lower"`     // limit-down price
+	Upper     decimal.Decimal `json:"upper"`     // limit-up price
+	Price     decimal.Decimal `json:"price"`     // price the symbol was held at
+	HaltedAt  time.Time       `json:"haltedAt"`
+	ResumesAt time.Time       `json:"resumesAt"`
+}
+
+// HaltEvent reports a symbol halting or resuming trading
+type HaltEvent struct {
+	Type string `json:"type"` // "halt" or "resume"
+	Halt Halt   `json:"halt"`
+}
+
+// SetCircuitBreakerPolicy replaces the limit-up/limit-down bands and halt rules
+func (s *Storage) SetCircuitBreakerPolicy(policy CircuitBreakerPolicy) {
+	s.haltsMutex.Lock()
+	defer s.haltsMutex.Unlock()
+	s.breakers = policy
+}
+
+// circuitBreakerPolicy returns the current circuit breaker policy
+func (s *Storage) circuitBreakerPolicy() CircuitBreakerPolicy {
+	s.haltsMutex.RLock()
+	defer s.haltsMutex.RUnlock()
+	return s.breakers
+}
+
+// Halts returns the symbols currently halted, by symbol
+func (s *Storage) Halts() []Halt {
+	s.haltsMutex.RLock()
+	defer s.haltsMutex.RUnlock()
+
+	halts := make([]Halt, 0, len(s.halts))
+	for _, halt := range s.halts {
+		halts = append(halts, *halt)
+	}
+	sort.Slice(halts, func(i, j int) bool { return halts[i].Symbol < halts[j].Symbol })
+	return halts
+}
+
+// halted returns the halt on symbol, if any
+func (s *Storage) halted(symbol string) (Halt, bool) {
+	s.haltsMutex.RLock()
+	defer s.haltsMutex.RUnlock()
+	if halt, exists := s.halts[symbol]; exists {
+		return *halt, true
+	}
+	return Halt{}, false
+}
+
+// haltError is the error for an order on a halted symbol
+func haltError(halt Halt) error {
+	return &OrderError{fmt.Sprintf("Trading in %s is halted until %s", halt.Symbol, halt.ResumesAt.Format(time.RFC3339))}
+}
+
+// checkBands applies the circuit breaker to a new price for symbol observed
+// at the given time. It returns false while the symbol is halted, when the
+// price must not change. A price outside the bands is moved to the band it
+// breached and halts the symbol. The reference window ends at the price's
+// time, on the same clock as the price history, while halts run on the wall
+// clock. The returned change is recomputed if the price was moved.
+func (s *Storage) checkBands(symbol string, newPrice decimal.Decimal, change float64, at time.Time) (decimal.Decimal, float64, bool) {
+	s.haltsMutex.Lock()
+	defer s.haltsMutex.Unlock()
+
+	if _, halted := s.halts[symbol]; halted {
+		return newPrice, change, false
+	}
+	policy := s.breakers
+	price, exists := s.GetPrice(symbol)
+	if policy.Band <= 0 || !exists {
+		return newPrice, change, true
+	}
+
+	reference := price.Price
+	if points := s.ticks.Range(symbol, at.Add(-policy.Window), at, 0); len(points) > 0 {
+		var sum decimal.Decimal
+		for _, p := range points {
+			sum += p.Price
+		}
+		reference = sum.DivInt(len(points))
+	}
+	lower := (reference - reference.Mul(policy.Band)).Round(price.TickSize)
+	upper := (reference + reference.Mul(policy.Band)).Round(price.TickSize)
+	if newPrice >= lower && newPrice <= upper {
+		return newPrice, change, true
+	}
+
+	held := decimal.Max(lower, decimal.Min(upper, newPrice))
+	now := time.Now()
+	halt := &Halt{
+		Symbol:    symbol,
+		Reference: reference,
+		Lower:     lower,
+		Upper:     upper,
+		Price:     held,
+		HaltedAt:  now,
+		ResumesAt: now.Add(policy.Cooldown),
+	}
+	s.halts[symbol] = halt
+	s.haltEvents = append(s.haltEvents, HaltEvent{Type: "halt", Halt: *halt})
+	log.Printf("Halted %s at %s: %s is outside %s-%s", symbol, held, newPrice, lower, upper)
+	return held, (held - price.Price).Ratio(price.Price) * 100.0, true
+}
+
+// queue holds an order on a halted symbol until trading resumes. Nothing
+// is reserved or checked until then. Callers must hold ordersMutex.
+func (s *Storage) queue(order *Order) {
+	order.Status = "queued"
+	s.queued[order.Symbol] = append(s.queued[order.Symbol], order)
+	s.recordOrder(order)
+	s.touch(order)
+}
+
+// unqueue removes a queued order. Callers must hold ordersMutex.
+func (s *Storage) unqueue(order *Order) {
+	queued := s.queued[order.Symbol]
+	for i, candidate := range queued {
+		if candidate == order {
+			s.queued[order.Symbol] = append(queued[:i], queued[i+1:]...)
+			break
+		}
+	}
+}
+
+// CheckHalts lifts the halts whose cooldown has passed and submits the
+// orders queued on them, meant to be called after each simulator tick. It
+// returns the halts and resumptions since the last call.
+func (s *Storage) CheckHalts(now time.Time) []HaltEvent {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+
+	s.haltsMutex.Lock()
+	for symbol, halt := range s.halts {
+		if now.Before(halt.ResumesAt) {
+			continue
+		}
+		delete(s.halts, symbol)
+		s.haltEvents = append(s.haltEvents, HaltEvent{Type: "resume", Halt: *halt})
+		log.Printf("Resumed trading in %s", symbol)
+	}
+	events := s.haltEvents
+	s.haltEvents = nil
+	s.haltsMutex.Unlock()
+
+	// Queued orders are submitted in the order they arrived
+	symbols := make([]string, 0, len(s.queued))
+	for symbol := range s.queued {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		if _, halted := s.halted(symbol); halted {
+			continue
+		}
+		queued := s.queued[symbol]
+		delete(s.queued, symbol)
+		for _, order := range queued {
+			var err error
+			if order.IsStop() {
+				err = s.arm(order)
+			} else {
+				err = s.submit(order)
+			}
+			if err != nil {
+				log.Printf("Queued order %s for %s rejected on resume: %v", order.ID, order.Username, err)
+				order.Status = "rejected"
+			}
+			now := time.Now()
+			order.UpdatedAt = &now
+			s.touch(order)
+		}
+	}
+	s.settleGroups()
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Halt.Symbol < events[j].Halt.Symbol })
+	return events
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckBandsOnReplayedPrices(t *testing.T) {
+	tests := []struct {
+		name      string
+		prices    []string // one bar a minute, long before now
+		halted    bool
+		reference string
+		price     string // the price the last bar left
+	}{
+		{
+			name:   "gradual moves stay inside the bands",
+			prices: []string{"100", "104", "108", "112", "116"},
+			price:  "116",
+		},
+		{
+			name: "the reference is the average over the window of bars",
+			// 118 is within 10% of the last price, 109, but not of the
+			// average of the five bars before it, 101.8
+			prices: []string{"100", "100", "100", "100", "109", "118"},
+			halted: true, reference: "101.8", price: "111.98",
+		},
+		{
+			name:   "a drop through the lower band is held at it",
+			prices: []string{"100", "100", "100", "85"},
+			halted: true, reference: "100", price: "90",
+		},
+	}
+
+	start := time.Date(2024, 3, 1, 14, 30, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStorage(t)
+			s.SetCircuitBreakerPolicy(CircuitBreakerPolicy{Band: dec("0.1"), Window: 5 * time.Minute, Cooldown: time.Minute})
+			var last time.Time
+			for i, price := range tt.prices {
+				last = start.Add(time.Duration(i) * time.Minute)
+				s.UpdatePrice("AAA", dec(price), 0, last)
+			}
+
+			halt, halted := s.halted("AAA")
+			if halted != tt.halted {
+				t.Fatalf("halted = %v, want %v", halted, tt.halted)
+			}
+			if halted {
+				if halt.Reference != dec(tt.reference) {
+					t.Errorf("reference = %s, want %s", halt.Reference, tt.reference)
+				}
+				// Halts last for the cooldown on the wall clock, whatever
+				// the time of the bars
+				if !halt.ResumesAt.After(time.Now()) {
+					t.Errorf("resumes at %s, which has passed", halt.ResumesAt)
+				}
+			}
+			if price, _ := s.GetPrice("AAA"); price.Price != dec(tt.price) {
+				t.Errorf("price = %s, want %s", price.Price, tt.price)
+			}
+			points, _ := s.PriceTicks("AAA", time.Time{}, time.Time{}, 0)
+			if n := len(points); n != len(tt.prices) || !points[n-1].Time.Equal(last) || points[n-1].Price != dec(tt.price) {
+				t.Errorf("history = %+v, want %d ticks ending with %s at %s", points, len(tt.prices), tt.price, last)
+			}
+		})
+	}
+}
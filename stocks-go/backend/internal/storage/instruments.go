@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/matching"
+	"strings"
+	"time"
+)
+
+// Instrument is the reference data of a tradable symbol
+type Instrument struct {
+	Symbol       string          `json:"symbol"`
+	Name         string          `json:"name"`
+	Logo         string          `json:"logo,omitempty"`
+	Sector       string          `json:"sector,omitempty"`
+	Currency     string          `json:"currency"`     // ISO 4217 code, such as USD
+	TickSize     decimal.Decimal `json:"tickSize"`     // prices are multiples of this
+	LotSize      int             `json:"lotSize"`      // order quantities are multiples of this
+	InitialPrice decimal.Decimal `json:"initialPrice"` // price the symbol started trading at
+
+	// Model holds the simulated price model's parameters as a
+	// simulation.ModelConfig, which storage does not interpret
+	Model json.RawMessage `json:"model,omitempty"`
+
+	ListedAt time.Time `json:"listedAt"`
+}
+
+// DefaultInstruments are listed when no other universe is configured
+var DefaultInstruments = []Instrument{
+	{Symbol: "AAPL", Name: "Apple Inc.", Logo: "https://logo.clearbit.com/apple.com", Sector: "Technology", InitialPrice: decimal.FromInt(150)},
+	{Symbol: "TSLA", Name: "Tesla, Inc.", Logo: "https://logo.clearbit.com/tesla.com", Sector: "Automotive", InitialPrice: decimal.FromInt(250)},
+	{Symbol: "AMZN", Name: "Amazon.com, Inc.", Logo: "https://logo.clearbit.com/amazon.com", Sector: "Technology", InitialPrice: decimal.FromInt(135)},
+	{Symbol: "GOOGL", Name: "Alphabet Inc.", Logo: "https://logo.clearbit.com/google.com", Sector: "Technology", InitialPrice: decimal.FromInt(140)},
+	{Symbol: "MSFT", Name: "Microsoft Corporation", Logo: "https://logo.clearbit.com/microsoft.com", Sector: "Technology", InitialPrice: decimal.FromInt(380)},
+}
+
+// ErrInstrumentListed is returned when listing a symbol that is already listed
+var ErrInstrumentListed = errors.New("Instrument is already listed")
+
+var (
+	symbolPattern   = regexp.MustCompile(`^[A-Z][A-Z0-9.]{0,9}$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// LoadInstruments reads a universe of instruments from a JSON file holding
+// an array of Instrument objects
+func LoadInstruments(path string) ([]Instrument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var instruments []Instrument
+	if err := json.Unmarshal(data, &instruments); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	seen := make(map[string]bool, len(instruments))
+	for i := range instruments {
+		if err := instruments[i].normalize(); err != nil {
+			return nil, fmt.Errorf("instrument %d in %s: %w", i+1, path, err)
+		}
+		if seen[instruments[i].Symbol] {
+			return nil, fmt.Errorf("%s is listed twice in %s", instruments[i].Symbol, path)
+		}
+		seen[instruments[i].Symbol] = true
+	}
+	return instruments, nil
+}
+
+// normalize checks an instrument's reference data and fills in the
+// defaults: prices in cents, single-share lots and USD
+func (i *Instrument) normalize() error {
+	i.Symbol = strings.ToUpper(strings.TrimSpace(i.Symbol))
+	i.Name = strings.TrimSpace(i.Name)
+	i.Currency = strings.ToUpper(strings.TrimSpace(i.Currency))
+	if i.Currency == "" {
+		i.Currency = "USD"
+	}
+	if i.TickSize == 0 {
+		i.TickSize = decimal.Cent
+	}
+	if i.LotSize == 0 {
+		i.LotSize = 1
+	}
+
+	if !symbolPattern.MatchString(i.Symbol) {
+		return fmt.Errorf("symbol %q must be 1 to 10 letters, digits or dots, starting with a letter", i.Symbol)
+	}
+	if i.Name == "" {
+		return fmt.Errorf("%s needs a name", i.Symbol)
+	}
+	if !currencyPattern.MatchString(i.Currency) {
+		return fmt.Errorf("currency %q must be a three letter code such as USD", i.Currency)
+	}
+	if i.TickSize < 0 {
+		return fmt.Errorf("tickSize must be greater than 0")
+	}
+	if i.LotSize < 0 {
+		return fmt.Errorf("lotSize must be greater than 0")
+	}
+	if i.InitialPrice = i.InitialPrice.Round(i.TickSize); i.InitialPrice <= 0 {
+		return fmt.Errorf("initialPrice must be at least the tick size")
+	}
+	return nil
+}
+
+// Instruments returns the listed instruments, by symbol
+func (s *Storage) Instruments() []Instrument {
+	s.pricesMutex.RLock()
+	defer s.pricesMutex.RUnlock()
+
+	instruments := make([]Instrument, 0, len(s.instruments))
+	for _, instrument := range s.instruments {
+		instruments = append(instruments, *instrument)
+	}
+	sort.Slice(instruments, func(i, j int) bool { return instruments[i].Symbol < instruments[j].Symbol })
+	return instruments
+}
+
+// Instrument returns the reference data of a listed symbol
+func (s *Storage) Instrument(symbol string) (Instrument, bool) {
+	s.pricesMutex.RLock()
+	defer s.pricesMutex.RUnlock()
+	if instrument, listed := s.instruments[symbol]; listed {
+		return *instrument, true
+	}
+	return Instrument{}, false
+}
+
+// ListInstrument checks an instrument's reference data and opens trading in
+// it at its initial price. It returns the instrument as listed, with the
+// defaults filled in.
+func (s *Storage) ListInstrument(instrument Instrument) (Instrument, error) {
+	if err := instrument.normalize(); err != nil {
+		return Instrument{}, err
+	}
+	instrument.ListedAt = time.Now()
+
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+	s.pricesMutex.Lock()
+	defer s.pricesMutex.Unlock()
+
+	if _, listed := s.instruments[instrument.Symbol]; listed {
+		return Instrument{}, ErrInstrumentListed
+	}
+	s.list(&instrument, instrument.InitialPrice)
+	log.Printf("Listed %s (%s) at %s", instrument.Symbol, instrument.Name, instrument.InitialPrice)
+	return instrument, nil
+}
+
+// list adds an instrument, priced at price, and its order book. Callers
+// must hold ordersMutex and pricesMutex.
+func (s *Storage) list(instrument *Instrument, price decimal.Decimal) {
+	s.instruments[instrument.Symbol] = instrument
+	s.prices[instrument.Symbol] = &StockPrice{
+		Symbol:       instrument.Symbol,
+		Price:        price,
+		PriceHistory: []decimal.Decimal{price},
+		TickSize:     instrument.TickSize,
+		LotSize:      instrument.LotSize,
+		Logo:         instrument.Logo,
+		Name:         instrument.Name,
+	}
+	if _, exists := s.books[instrument.Symbol]; !exists {
+		s.books[instrument.Symbol] = matching.NewOrderBook(instrument.Symbol)
+	}
+}
+
+// Settlement is one account's position closed out by a delisting
+type Settlement struct {
+	Username string          `json:"username"`
+	Quantity int             `json:"quantity"` // shares held, negative for shorts
+	Price    decimal.Decimal `json:"price"`
+	Amount   decimal.Decimal `json:"amount"` // credited to the account, negative for shorts
+}
+
+// Delisting reports the effects of delisting an instrument
+type Delisting struct {
+	Instrument  Instrument      `json:"instrument"`
+	Price       decimal.Decimal `json:"price"` // price positions were settled at
+	DelistedAt  time.Time       `json:"delistedAt"`
+	Orders      []Order         `json:"orders"` // open orders cancelled
+	Settlements []Settlement    `json:"settlements"`
+}
+
+// DelistInstrument ends trading in symbol. Its open orders are cancelled,
+// releasing what they reserved, and every position in it is closed out for
+// cash at price, or at the last price when price is zero: longs are paid
+// and shorts pay the simulated market, realizing their P&L without
+// commission. Corporate actions scheduled on it are cancelled. The
+// symbol's price, order book and halt are removed; its orders, trades and
+// price history are kept.
+func (s *Storage) DelistInstrument(symbol string, price decimal.Decimal) (Delisting, error) {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+
+	instrument, listed := s.Instrument(symbol)
+	current, exists := s.GetPrice(symbol)
+	if !listed || !exists {
+		return Delisting{}, ErrStockNotFound
+	}
+	if price = price.Round(current.TickSize); price <= 0 {
+		price = current.Price
+	}
+	now := time.Now()
+	delisting := Delisting{
+		Instrument:  instrument,
+		Price:       price,
+		DelistedAt:  now,
+		Orders:      make([]Order, 0),
+		Settlements: make([]Settlement, 0),
+	}
+
+	for _, order := range s.orders {
+		if order.Symbol == symbol && order.IsOpen() {
+			s.withdraw(order, "cancelled", now)
+			s.touch(order)
+			delisting.Orders = append(delisting.Orders, *order)
+		}
+	}
+	s.settleGroups()
+
+	method := s.costBasisMethod()
+	for _, account := range s.holders() {
+		account.mutex.Lock()
+		if quantity := account.Portfolio[symbol]; quantity != 0 {
+			amount := price.MulInt(quantity)
+			s.post(LedgerEntry{
+				Type:      "delisting",
+				Reference: symbol,
+				Memo:      fmt.Sprintf("%d %s settled @ %s on delisting", quantity, symbol, price),
+				Postings: []Posting{
+					{Account: cashAccount(account.Username), Amount: amount},
+					{Account: houseMarket, Amount: -amount},
+				},
+			}, account)
+			account.bookFill(symbol, -quantity, price, decimal.Zero, method, now)
+			delete(account.Portfolio, symbol)
+			delete(account.ReservedShares, symbol)
+			s.accountChanged(account)
+			delisting.Settlements = append(delisting.Settlements, Settlement{
+				Username: account.Username,
+				Quantity: quantity,
+				Price:    price,
+				Amount:   amount,
+			})
+		}
+		account.mutex.Unlock()
+	}
+
+	s.cancelCorporateActions(symbol)
+	s.unlist(symbol)
+	log.Printf("Delisted %s at %s: %d orders cancelled, %d positions settled",
+		symbol, price, len(delisting.Orders), len(delisting.Settlements))
+	return delisting, nil
+}
+
+// unlist removes a symbol's price, reference data, order book, armed stops,
+// queued orders, halt and bars. Callers must hold ordersMutex.
+func (s *Storage) unlist(symbol string) {
+	delete(s.books, symbol)
+	delete(s.stops, symbol)
+	delete(s.queued, symbol)
+
+	s.haltsMutex.Lock()
+	delete(s.halts, symbol)
+	s.haltsMutex.Unlock()
+
+	s.pricesMutex.Lock()
+	delete(s.prices, symbol)
+	delete(s.instruments, symbol)
+	s.pricesMutex.Unlock()
+
+	s.candlesMutex.Lock()
+	delete(s.candles, symbol)
+	delete(s.feedTime, symbol)
+	s.candlesMutex.Unlock()
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"log"
+	"stocks-backend/internal/decimal"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Every change to an account's credits is posted to an append-only,
+// double-entry ledger. Each entry moves cash between ledger accounts and its
+// postings sum to zero. Users' cash lives in "cash:<username>" accounts and
+// the other side of every entry is another user or one of the house
+// accounts below, so UserAccount.Credits always equals the sum of the
+// postings to the user's cash account.
+const (
+	houseCapital = "house:capital" // funds deposits and opening balances
+	houseMarket  = "house:market"  // the simulated market's side of trades
+	houseFees    = "house:fees"    // commission income
+	houseIssuers = "house:issuers" // pays dividends and cash for fractional shares
+)
+
+// LedgerEntry is one balanced journal entry
+type LedgerEntry struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`                // "deposit", "trade", "fee", "adjustment", "delisting", "split" or "dividend"
+	Reference string    `json:"reference,omitempty"` // trade ID for trades and fees, symbol for delistings, action ID for splits and dividends
+	Memo      string    `json:"memo"`
+	Postings  []Posting `json:"postings"`
+	PostedAt  time.Time `json:"postedAt"`
+}
+
+// Posting moves Amount into a ledger account; negative amounts move cash out
+type Posting struct {
+	Account string          `json:"account"`
+	Amount  decimal.Decimal `json:"amount"`
+}
+
+// cashAccount names the ledger account holding a user's cash. An empty
+// username is the simulated market.
+func cashAccount(username string) string {
+	if username == "" {
+		return houseMarket
+	}
+	return "cash:" + username
+}
+
+// post stamps a balanced entry, applies it to the credits of the user
+// accounts it names and appends it to the ledger. Callers must hold the
+// mutex of every account passed in, and pass every user account the entry
+// posts to.
+func (s *Storage) post(entry LedgerEntry, accounts ...*UserAccount) {
+	entry.ID = uuid.New().String()
+	entry.PostedAt = time.Now()
+	for _, posting := range entry.Postings {
+		for _, account := range accounts {
+			if account != nil && posting.Account == cashAccount(account.Username) {
+				account.Credits += posting.Amount
+				s.accountChanged(account)
+			}
+		}
+	}
+
+	s.ledgerMutex.Lock()
+	defer s.ledgerMutex.Unlock()
+	s.ledger = append(s.ledger, entry)
+}
+
+// SetFeeRate sets the commission charged to each user side of a trade, as
+// a fraction of the trade's value
+func (s *Storage) SetFeeRate(rate decimal.Decimal) {
+	s.accountsMutex.Lock()
+	defer s.accountsMutex.Unlock()
+	s.fees = rate
+}
+
+// feeRate returns the current commission rate
+func (s *Storage) feeRate() decimal.Decimal {
+	s.accountsMutex.RLock()
+	defer s.accountsMutex.RUnlock()
+	return s.fees
+}
+
+// buyCost returns what buying quantity at price costs including commission,
+// which is what buys must be able to fund and what they reserve
+func (s *Storage) buyCost(quantity int, price decimal.Decimal) decimal.Decimal {
+	return withFee(price.MulInt(quantity), s.feeRate())
+}
+
+// withFee returns value plus the commission on it at rate
+func withFee(value, rate decimal.Decimal) decimal.Decimal {
+	return value + value.Mul(rate)
+}
+
+// LedgerLine is one entry of a user's ledger as seen from their cash account
+type LedgerLine struct {
+	EntryID   string          `json:"entryId"`
+	Type      string          `json:"type"`
+	Reference string          `json:"reference,omitempty"`
+	Memo      string          `json:"memo"`
+	Amount    decimal.Decimal `json:"amount"`  // change to the user's cash
+	Balance   decimal.Decimal `json:"balance"` // cash after the entry
+	PostedAt  time.Time       `json:"postedAt"`
+}
+
+// GetLedger returns the entries posted to a user's cash account, oldest first
+func (s *Storage) GetLedger(username string) []LedgerLine {
+	s.ledgerMutex.RLock()
+	defer s.ledgerMutex.RUnlock()
+
+	account := cashAccount(username)
+	lines := make([]LedgerLine, 0)
+	balance := decimal.Zero
+	for _, entry := range s.ledger {
+		amount, posted := decimal.Zero, false
+		for _, posting := range entry.Postings {
+			if posting.Account == account {
+				amount += posting.Amount
+				posted = true
+			}
+		}
+		if !posted {
+			continue
+		}
+
+		balance += amount
+		lines = append(lines, LedgerLine{
+			EntryID:   entry.ID,
+			Type:      entry.Type,
+			Reference: entry.Reference,
+			Memo:      entry.Memo,
+			Amount:    amount,
+			Balance:   balance,
+			PostedAt:  entry.PostedAt,
+		})
+	}
+	return lines
+}
+
+// LedgerReport is the result of an integrity check of the ledger
+type LedgerReport struct {
+	Entries    int             `json:"entries"`
+	Accounts   int             `json:"accounts"`   // ledger accounts with postings
+	Total      decimal.Decimal `json:"total"`      // sum of every posting, zero when the books balance
+	Unbalanced []string        `json:"unbalanced"` // IDs of entries whose postings do not sum to zero
+	Mismatched int             `json:"mismatched"` // user accounts whose credits differ from their ledger balance
+	Balanced   bool            `json:"balanced"`
+}
+
+// CheckLedger proves the books balance: every entry sums to zero, so all
+// ledger accounts together do too, and every user's credits equal the
+// balance of their cash account
+func (s *Storage) CheckLedger() LedgerReport {
+	// Trades post under ordersMutex and deposits under accountsMutex, so
+	// holding both keeps the ledger and the balances in step while checking
+	s.ordersMutex.RLock()
+	defer s.ordersMutex.RUnlock()
+	s.accountsMutex.RLock()
+	defer s.accountsMutex.RUnlock()
+
+	s.ledgerMutex.RLock()
+	entries := s.ledger
+	s.ledgerMutex.RUnlock()
+
+	report := LedgerReport{Entries: len(entries), Unbalanced: make([]string, 0)}
+	balances := make(map[string]decimal.Decimal)
+	for _, entry := range entries {
+		sum := decimal.Zero
+		for _, posting := range entry.Postings {
+			sum += posting.Amount
+			balances[posting.Account] += posting.Amount
+		}
+		if sum != 0 {
+			report.Unbalanced = append(report.Unbalanced, entry.ID)
+		}
+		report.Total += sum
+	}
+	report.Accounts = len(balances)
+
+	for username, account := range s.accounts {
+		account.mutex.RLock()
+		credits := account.Credits
+		account.mutex.RUnlock()
+
+		if balance := balances[cashAccount(username)]; credits != balance {
+			log.Printf("Ledger mismatch for %s: credits %s, ledger balance %s", username, credits, balance)
+			report.Mismatched++
+		}
+	}
+
+	report.Balanced = len(report.Unbalanced) == 0 && report.Mismatched == 0 && report.Total == 0
+	return report
+}
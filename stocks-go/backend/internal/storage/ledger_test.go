@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"stocks-backend/internal/decimal"
+	"testing"
+)
+
+// dec parses a decimal for a test
+func dec(s string) decimal.Decimal {
+	d, err := decimal.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// newTestStorage lists AAA at 100 with a cent tick and creates a cash
+// account for each username
+func newTestStorage(t *testing.T, usernames ...string) *Storage {
+	t.Helper()
+	s := newStorage()
+	if _, err := s.ListInstrument(Instrument{Symbol: "AAA", Name: "Test", InitialPrice: dec("100")}); err != nil {
+		t.Fatalf("ListInstrument: %v", err)
+	}
+	for _, username := range usernames {
+		s.CreateAccount(username, "secret", "cash")
+	}
+	return s
+}
+
+// submit places an order for AAA, failing the test if it is rejected
+func submit(t *testing.T, s *Storage, username, side, orderType string, quantity int, price string) *Order {
+	t.Helper()
+	order := &Order{
+		ID:        username + "-" + side + "-" + price,
+		Username:  username,
+		Symbol:    "AAA",
+		Side:      side,
+		OrderType: orderType,
+		Quantity:  quantity,
+	}
+	if price != "" {
+		order.Price = dec(price)
+	}
+	if err := s.SubmitOrder(order); err != nil {
+		t.Fatalf("SubmitOrder(%s %s %d @ %s): %v", username, side, quantity, price, err)
+	}
+	return order
+}
+
+func TestPostAppliesPostingsToNamedAccounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		postings []Posting
+		accounts []string // accounts passed to post
+		want     map[string]string
+	}{
+		{
+			name:     "user to user",
+			postings: []Posting{{cashAccount("alice"), dec("-25.5")}, {cashAccount("bob"), dec("25.5")}},
+			accounts: []string{"alice", "bob"},
+			want:     map[string]string{"alice": "1974.5", "bob": "2025.5"},
+		},
+		{
+			name:     "house to user",
+			postings: []Posting{{cashAccount("alice"), dec("10")}, {houseIssuers, dec("-10")}},
+			accounts: []string{"alice"},
+			want:     map[string]string{"alice": "2010", "bob": "2000"},
+		},
+		{
+			name:     "the market is not a user",
+			postings: []Posting{{cashAccount(""), dec("-7")}, {cashAccount("bob"), dec("7")}},
+			accounts: []string{"", "bob"},
+			want:     map[string]string{"alice": "2000", "bob": "2007"},
+		},
+		{
+			name:     "accounts not passed in are left alone",
+			postings: []Posting{{cashAccount("alice"), dec("-1")}, {cashAccount("bob"), dec("1")}},
+			accounts: []string{"alice"},
+			want:     map[string]string{"alice": "1999", "bob": "2000"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStorage(t, "alice", "bob")
+			accounts := make([]*UserAccount, 0, len(tt.accounts))
+			for _, username := range tt.accounts {
+				accounts = append(accounts, s.GetAccount(username))
+			}
+			s.post(LedgerEntry{Type: "adjustment", Postings: tt.postings}, accounts...)
+
+			for username, want := range tt.want {
+				if got := s.GetAccount(username).Credits; got != dec(want) {
+					t.Errorf("%s credits = %s, want %s", username, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckLedger(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T, s *Storage)
+		entries    int
+		unbalanced int
+		mismatched int
+		balanced   bool
+	}{
+		{
+			name:     "deposits",
+			setup:    func(t *testing.T, s *Storage) {},
+			entries:  2,
+			balanced: true,
+		},
+		{
+			name: "trades with the market and between users, with fees",
+			setup: func(t *testing.T, s *Storage) {
+				s.SetFeeRate(dec("0.001"))
+				submit(t, s, "alice", "buy", "market", 10, "")
+				submit(t, s, "bob", "buy", "limit", 4, "99")
+				submit(t, s, "alice", "sell", "limit", 4, "99")
+			},
+			// Two deposits, then a trade and a fee for alice's buy, and a
+			// trade and a fee for each side of the trade between them
+			entries:  2 + 2 + 3,
+			balanced: true,
+		},
+		{
+			name: "an entry that does not sum to zero",
+			setup: func(t *testing.T, s *Storage) {
+				s.post(LedgerEntry{Type: "adjustment", Postings: []Posting{{houseCapital, dec("1")}}})
+			},
+			entries:    3,
+			unbalanced: 1,
+		},
+		{
+			name: "credits changed outside the ledger",
+			setup: func(t *testing.T, s *Storage) {
+				s.GetAccount("bob").Credits += dec("0.01")
+			},
+			entries:    2,
+			mismatched: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStorage(t, "alice", "bob")
+			tt.setup(t, s)
+
+			report := s.CheckLedger()
+			if report.Entries != tt.entries || len(report.Unbalanced) != tt.unbalanced ||
+				report.Mismatched != tt.mismatched || report.Balanced != tt.balanced {
+				t.Errorf("CheckLedger() = %+v, want %d entries, %d unbalanced, %d mismatched, balanced %v",
+					report, tt.entries, tt.unbalanced, tt.mismatched, tt.balanced)
+			}
+			if tt.unbalanced == 0 && report.Total != 0 {
+				t.Errorf("total = %s, want 0", report.Total)
+			}
+		})
+	}
+}
+
+func TestGetLedger(t *testing.T) {
+	s := newTestStorage(t, "alice", "bob")
+	s.SetFeeRate(dec("0.01"))
+	submit(t, s, "alice", "buy", "market", 3, "")
+
+	want := []struct {
+		typ     string
+		amount  string
+		balance string
+	}{
+		{"deposit", "2000", "2000"},
+		{"trade", "-300", "1700"},
+		{"fee", "-3", "1697"},
+	}
+	lines := s.GetLedger("alice")
+	if len(lines) != len(want) {
+		t.Fatalf("GetLedger returned %d lines, want %d: %+v", len(lines), len(want), lines)
+	}
+	for i, w := range want {
+		line := lines[i]
+		if line.Type != w.typ || line.Amount != dec(w.amount) || line.Balance != dec(w.balance) {
+			t.Errorf("line %d = %s %s -> %s, want %s %s -> %s", i, line.Type, line.Amount, line.Balance, w.typ, w.amount, w.balance)
+		}
+	}
+	if got := s.GetAccount("alice").Credits; got != lines[len(lines)-1].Balance {
+		t.Errorf("credits %s differ from the ledger balance %s", got, lines[len(lines)-1].Balance)
+	}
+	if got := s.GetLedger("bob"); len(got) != 1 {
+		t.Errorf("bob has %d lines, want only the deposit", len(got))
+	}
+}
@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"log"
+	"sort"
+	"stocks-backend/internal/decimal"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MarginPolicy holds the margin requirements for margin accounts, as
+// fractions of gross position value
+type MarginPolicy struct {
+	InitialMargin     decimal.Decimal `json:"initialMargin"`     // equity needed to open positions
+	MaintenanceMargin decimal.Decimal `json:"maintenanceMargin"` // equity below which positions are liquidated
+}
+
+// DefaultMarginPolicy is the Reg T style 50% initial / 25% maintenance policy
+var DefaultMarginPolicy = MarginPolicy{InitialMargin: decimal.New(5, 1), MaintenanceMargin: decimal.New(25, 2)}
+
+// SetMarginPolicy replaces the margin requirements used for margin accounts
+func (s *Storage) SetMarginPolicy(policy MarginPolicy) {
+	s.accountsMutex.Lock()
+	defer s.accountsMutex.Unlock()
+	s.margin = policy
+}
+
+// MarginStatus reports a margin account's equity, requirements and usage
+type MarginStatus struct {
+	Equity                 decimal.Decimal `json:"equity"`
+	LongValue              decimal.Decimal `json:"longValue"`
+	ShortValue             decimal.Decimal `json:"shortValue"`
+	InitialRequirement     decimal.Decimal `json:"initialRequirement"`
+	MaintenanceRequirement decimal.Decimal `json:"maintenanceRequirement"`
+	BuyingPower            decimal.Decimal `json:"buyingPower"`
+	AvailableBuyingPower   decimal.Decimal `json:"availableBuyingPower"` // after what resting orders hold
+	MarginUsage            float64         `json:"marginUsage"`          // initial requirement / equity
+	MarginCall             bool            `json:"marginCall"`
+}
+
+// IsMargin reports whether the account may borrow and sell short
+func (a *UserAccount) IsMargin() bool {
+	return a.AccountType == "margin"
+}
+
+// reducesPosition reports whether an order of quantity on side only shrinks
+// the account's existing position. Callers must hold the account mutex.
+func (a *UserAccount) reducesPosition(side, symbol string, quantity int) bool {
+	position := a.Portfolio[symbol]
+	if side == "buy" {
+		return position < 0 && quantity <= -position
+	}
+	return position > 0 && quantity <= position
+}
+
+// policy returns the current margin policy
+func (s *Storage) policy() MarginPolicy {
+	s.accountsMutex.RLock()
+	defer s.accountsMutex.RUnlock()
+	return s.margin
+}
+
+// marginStatus values an account's positions at current prices.
+// Callers must hold the account mutex.
+func (s *Storage) marginStatus(account *UserAccount) MarginStatus {
+	policy := s.policy()
+	status := MarginStatus{MarginCall: account.MarginCall != nil}
+
+	for symbol, quantity := range account.Portfolio {
+		price, exists := s.GetPrice(symbol)
+		if !exists {
+			continue
+		}
+		value := price.Price.MulInt(quantity)
+		if quantity > 0 {
+			status.LongValue += value
+		} else {
+			status.ShortValue -= value
+		}
+	}
+
+	gross := status.LongValue + status.ShortValue
+	status.Equity = account.Credits + status.LongValue - status.ShortValue
+	status.InitialRequirement = gross.Mul(policy.InitialMargin)
+	status.MaintenanceRequirement = gross.Mul(policy.MaintenanceMargin)
+	if policy.InitialMargin > 0 {
+		status.BuyingPower = decimal.Max(0, (status.Equity - status.InitialRequirement).Div(policy.InitialMargin))
+	}
+	status.AvailableBuyingPower = decimal.Max(0, status.BuyingPower-account.ReservedCredits)
+	if status.Equity > 0 {
+		status.MarginUsage = status.InitialRequirement.Ratio(status.Equity)
+	}
+	return status
+}
+
+// Margin returns a margin account's current margin status
+func (s *Storage) Margin(account *UserAccount) MarginStatus {
+	account.mutex.RLock()
+	defer account.mutex.RUnlock()
+	return s.marginStatus(account)
+}
+
+// MarginEvent describes a margin call or forced liquidation
+type MarginEvent struct {
+	Username string       `json:"username"`
+	Type     string       `json:"type"` // "marginCall", "marginCallCleared" or "liquidation"
+	Status   MarginStatus `json:"status"`
+	Orders   []Order      `json:"orders,omitempty"` // liquidation orders
+}
+
+// CheckMargins runs the maintenance check on every margin account, meant to
+// be called after each simulator tick. Accounts whose equity falls below the
+// initial requirement get a margin call, which is cleared once they recover.
+// Accounts below the maintenance requirement have their open orders
+// cancelled and positions closed at market, largest first, until they are
+// back above the initial requirement.
+func (s *Storage) CheckMargins() []MarginEvent {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+
+	s.accountsMutex.RLock()
+	accounts := make([]*UserAccount, 0)
+	for _, account := range s.accounts {
+		if account.IsMargin() {
+			accounts = append(accounts, account)
+		}
+	}
+	s.accountsMutex.RUnlock()
+
+	events := make([]MarginEvent, 0)
+	for _, account := range accounts {
+		account.mutex.Lock()
+		status := s.marginStatus(account)
+		now := time.Now()
+
+		switch {
+		case status.Equity < status.MaintenanceRequirement:
+			account.MarginCall = &now
+			s.accountChanged(account)
+			account.mutex.Unlock()
+			orders := s.liquidate(account)
+			events = append(events, MarginEvent{Username: account.Username, Type: "liquidation", Status: s.Margin(account), Orders: orders})
+			continue
+		case status.Equity < status.InitialRequirement && account.MarginCall == nil:
+			account.MarginCall = &now
+			s.accountChanged(account)
+			status.MarginCall = true
+			events = append(events, MarginEvent{Username: account.Username, Type: "marginCall", Status: status})
+		case status.Equity >= status.InitialRequirement && account.MarginCall != nil:
+			account.MarginCall = nil
+			s.accountChanged(account)
+			status.MarginCall = false
+			events = append(events, MarginEvent{Username: account.Username, Type: "marginCallCleared", Status: status})
+		}
+		account.mutex.Unlock()
+	}
+
+	s.settleGroups()
+	for _, event := range events {
+		log.Printf("Margin %s for %s: equity %s, maintenance %s", event.Type, event.Username, event.Status.Equity, event.Status.MaintenanceRequirement)
+	}
+	return events
+}
+
+// liquidate cancels an account's open orders and closes its positions at
+// market until it meets the initial requirement again. Positions in halted
+// symbols cannot be closed until trading resumes. Callers must hold
+// ordersMutex but not the account mutex.
+func (s *Storage) liquidate(account *UserAccount) []Order {
+	now := time.Now()
+	for _, order := range s.orders {
+		if order.Username == account.Username && order.IsOpen() {
+			s.withdraw(order, "cancelled", now)
+			s.touch(order)
+		}
+	}
+
+	account.mutex.RLock()
+	type position struct {
+		symbol   string
+		quantity int
+		price    decimal.Decimal
+	}
+	positions := make([]position, 0, len(account.Portfolio))
+	for symbol, quantity := range account.Portfolio {
+		if _, halted := s.halted(symbol); halted {
+			continue
+		}
+		if price, exists := s.GetPrice(symbol); exists {
+			positions = append(positions, position{symbol, quantity, price.Price})
+		}
+	}
+	account.mutex.RUnlock()
+	value := func(p position) decimal.Decimal { return p.price.MulInt(p.quantity).Abs() }
+	sort.Slice(positions, func(i, j int) bool { return value(positions[i]) > value(positions[j]) })
+
+	orders := make([]Order, 0)
+	for _, p := range positions {
+		status := s.Margin(account)
+		if status.Equity >= status.InitialRequirement {
+			break
+		}
+
+		side, quantity := "sell", p.quantity
+		if quantity < 0 {
+			side, quantity = "buy", -quantity
+		}
+		order := &Order{
+			ID:          uuid.New().String(),
+			Username:    account.Username,
+			Symbol:      p.symbol,
+			Side:        side,
+			OrderType:   "market",
+			Quantity:    quantity,
+			Price:       p.price,
+			CreatedAt:   now,
+			TimeInForce: "IOC",
+			Liquidation: true,
+		}
+		if err := s.submit(order); err != nil {
+			log.Printf("Liquidation of %s %s for %s failed: %v", p.symbol, side, account.Username, err)
+			continue
+		}
+		orders = append(orders, *order)
+	}
+
+	account.mutex.Lock()
+	if status := s.marginStatus(account); status.Equity >= status.InitialRequirement {
+		account.MarginCall = nil
+		s.accountChanged(account)
+	}
+	account.mutex.Unlock()
+	return orders
+}
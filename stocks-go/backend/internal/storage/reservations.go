@@ -0,0 +1,165 @@
+package storage
+
+import "stocks-backend/internal/decimal"
+
+// Resting limit orders hold the cash (buys) or shares (sells) they need so
+// the same balance cannot back several orders at once. The hold is sized
+// from the order's limit price, plus commission for cash, and released as
+// the order fills, is cancelled or expires. Orders in margin accounts hold
+// buying power instead, except those that only reduce an existing position,
+// which hold nothing.
+
+// AvailableCredits returns cash not held by resting orders; callers must hold the account mutex
+func (a *UserAccount) AvailableCredits() decimal.Decimal {
+	return a.Credits - a.ReservedCredits
+}
+
+// AvailableShares returns shares not held by resting orders; callers must hold the account mutex
+func (a *UserAccount) AvailableShares(symbol string) int {
+	return a.Portfolio[symbol] - a.ReservedShares[symbol]
+}
+
+// AccountBalances is a consistent snapshot of an account's cash and holdings
+type AccountBalances struct {
+	Credits            decimal.Decimal `json:"credits"`
+	AvailableCredits   decimal.Decimal `json:"availableCredits"`
+	ReservedCredits    decimal.Decimal `json:"reservedCredits"`
+	Portfolio          map[string]int  `json:"portfolio"`
+	AvailablePortfolio map[string]int  `json:"availablePortfolio"`
+	ReservedPortfolio  map[string]int  `json:"reservedPortfolio"`
+}
+
+// Balances returns the account's total, available and reserved balances
+func (a *UserAccount) Balances() AccountBalances {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	balances := AccountBalances{
+		Credits:            a.Credits,
+		AvailableCredits:   a.AvailableCredits(),
+		ReservedCredits:    a.ReservedCredits,
+		Portfolio:          make(map[string]int),
+		AvailablePortfolio: make(map[string]int),
+		ReservedPortfolio:  make(map[string]int),
+	}
+	for symbol, quantity := range a.Portfolio {
+		balances.Portfolio[symbol] = quantity
+		balances.AvailablePortfolio[symbol] = a.AvailableShares(symbol)
+	}
+	for symbol, quantity := range a.ReservedShares {
+		balances.ReservedPortfolio[symbol] = quantity
+	}
+	return balances
+}
+
+// holdFor decides what a resting order holds: "credits" (quantity × price
+// of cash or buying power), "shares", or "" for nothing
+func (s *Storage) holdFor(order *Order) string {
+	account := s.GetAccount(order.Username)
+	if account == nil {
+		return ""
+	}
+
+	account.mutex.RLock()
+	defer account.mutex.RUnlock()
+	if account.IsMargin() {
+		if account.reducesPosition(order.Side, order.Symbol, order.Remaining()) {
+			return ""
+		}
+		return "credits"
+	}
+	if order.Side == "buy" {
+		return "credits"
+	}
+	return "shares"
+}
+
+// reserve holds the cash or shares needed for quantity of a resting order
+func (s *Storage) reserve(order *Order, quantity int) {
+	account := s.GetAccount(order.Username)
+	if account == nil {
+		return
+	}
+	cost := s.buyCost(quantity, order.Price)
+
+	account.mutex.Lock()
+	defer account.mutex.Unlock()
+	s.accountChanged(account)
+	switch order.hold {
+	case "credits":
+		account.ReservedCredits += cost
+	case "shares":
+		account.ReservedShares[order.Symbol] += quantity
+	}
+}
+
+// release returns the cash or shares held for quantity of a resting order
+func (s *Storage) release(order *Order, quantity int) {
+	account := s.GetAccount(order.Username)
+	if account == nil {
+		return
+	}
+	cost := s.buyCost(quantity, order.Price)
+
+	account.mutex.Lock()
+	defer account.mutex.Unlock()
+	s.accountChanged(account)
+	switch order.hold {
+	case "credits":
+		account.ReservedCredits -= cost
+	case "shares":
+		account.ReservedShares[order.Symbol] -= quantity
+		if account.ReservedShares[order.Symbol] <= 0 {
+			delete(account.ReservedShares, order.Symbol)
+		}
+	}
+}
+
+// rest places quantity of an order on its book and reserves funds for it.
+// The book entry's remaining quantity always matches the order's Remaining().
+// Callers must hold ordersMutex.
+func (s *Storage) rest(order *Order, quantity int) {
+	order.hold = s.holdFor(order)
+	s.sequence++
+	order.seq = s.sequence
+	s.reserve(order, quantity)
+	s.books[order.Symbol].Add(order.ID, order.Username, order.Side, order.Price, quantity)
+}
+
+// unrest takes an order off its book and releases what it still reserves.
+// It returns the quantity that was resting. Callers must hold ordersMutex.
+func (s *Storage) unrest(order *Order) (int, bool) {
+	entry, resting := s.books[order.Symbol].Remove(order.ID)
+	if !resting {
+		return 0, false
+	}
+	s.release(order, entry.Remaining)
+	return entry.Remaining, true
+}
+
+// reduceResting shrinks a resting order without executing it.
+// Callers must hold ordersMutex.
+func (s *Storage) reduceResting(order *Order, quantity int) {
+	s.books[order.Symbol].Reduce(order.ID, quantity)
+	s.release(order, quantity)
+}
+
+// fillResting executes quantity of a resting order against counterparty
+// (nil for the simulated market) using the funds the order reserved, and
+// returns the quantity filled. The book entry itself is left for the caller
+// to update. Callers must hold ordersMutex.
+func (s *Storage) fillResting(order, counterparty *Order, quantity int, price decimal.Decimal) int {
+	s.release(order, quantity)
+
+	// The counterparty, or the market when there is none, is the aggressor
+	var filled int
+	if order.Side == "buy" {
+		filled = s.execute(order, counterparty, quantity, price, "sell")
+	} else {
+		filled = s.execute(counterparty, order, quantity, price, "buy")
+	}
+	if filled < quantity {
+		s.reserve(order, quantity-filled)
+	}
+	return filled
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"log"
+	"stocks-backend/internal/decimal"
+	"time"
+)
+
+// IsStop reports whether the order is a stop, stop-limit or trailing stop order
+func (o *Order) IsStop() bool {
+	return o.OrderType == "stop" || o.OrderType == "stop_limit" || o.OrderType == "trailing_stop"
+}
+
+// atMarket reports whether the order executes at the market price rather
+// than resting at a limit price
+func (o *Order) atMarket() bool {
+	return o.OrderType == "market" || o.OrderType == "stop" || o.OrderType == "trailing_stop"
+}
+
+// ratchet moves a trailing stop's trigger with the market. The reference is
+// the best price seen since the stop was armed (highest for sells, lowest
+// for buys) and the stop price trails it by the absolute or percentage trail.
+// The trigger only ever moves in the holder's favour and is rounded to the
+// symbol's tick size.
+func (o *Order) ratchet(price, tick decimal.Decimal) {
+	if o.OrderType != "trailing_stop" {
+		return
+	}
+	if o.TrailReference == 0 ||
+		(o.Side == "sell" && price > o.TrailReference) ||
+		(o.Side == "buy" && price < o.TrailReference) {
+		o.TrailReference = price
+	}
+
+	trail := o.TrailAmount
+	if o.TrailPercent > 0 {
+		trail = o.TrailReference.MulFloat(o.TrailPercent / 100)
+	}
+	if o.Side == "sell" {
+		o.StopPrice = (o.TrailReference - trail).Round(tick)
+	} else {
+		o.StopPrice = (o.TrailReference + trail).Round(tick)
+	}
+}
+
+// stopReached reports whether price triggers the stop: buy stops trigger at
+// or above their stop price and sell stops at or below it
+func (o *Order) stopReached(price decimal.Decimal) bool {
+	if o.Side == "buy" {
+		return price >= o.StopPrice
+	}
+	return price <= o.StopPrice
+}
+
+// arm validates a stop order and holds it until the market reaches its stop
+// price. Nothing is reserved while armed; funds are checked again when the
+// stop triggers. Callers must hold ordersMutex.
+func (s *Storage) arm(order *Order) error {
+	stockPrice, exists := s.GetPrice(order.Symbol)
+	if !exists {
+		return &OrderError{"Stock not found"}
+	}
+	order.ratchet(stockPrice.Price, stockPrice.TickSize)
+	if order.stopReached(stockPrice.Price) {
+		return &OrderError{"Stop price has already been reached"}
+	}
+
+	estimate := order.StopPrice
+	if !order.atMarket() {
+		estimate = order.Price
+	}
+	if err := s.checkFunds(order, order.Quantity, estimate); err != nil {
+		return err
+	}
+
+	s.armUnchecked(order)
+	return nil
+}
+
+// armUnchecked arms a stop without validating it, for group legs whose
+// funding is shared with a sibling order. Callers must hold ordersMutex.
+func (s *Storage) armUnchecked(order *Order) {
+	if stockPrice, exists := s.GetPrice(order.Symbol); exists {
+		order.ratchet(stockPrice.Price, stockPrice.TickSize)
+	}
+	order.Status = "armed"
+	s.sequence++
+	order.seq = s.sequence
+	s.stops[order.Symbol] = append(s.stops[order.Symbol], order)
+	s.recordOrder(order)
+	s.orderChanged(order)
+}
+
+// disarm removes an armed stop so it can no longer trigger.
+// Callers must hold ordersMutex.
+func (s *Storage) disarm(order *Order) {
+	armed := s.stops[order.Symbol]
+	for i, stop := range armed {
+		if stop == order {
+			s.stops[order.Symbol] = append(armed[:i], armed[i+1:]...)
+			return
+		}
+	}
+}
+
+// triggerStops ratchets trailing stops and then submits every armed stop on
+// symbol that price has reached, in the order they were armed. Triggered
+// stops that can no longer be funded are rejected. Callers must hold ordersMutex.
+func (s *Storage) triggerStops(symbol string, price decimal.Decimal) {
+	tick := decimal.Cent
+	if stockPrice, exists := s.GetPrice(symbol); exists {
+		tick = stockPrice.TickSize
+	}
+
+	armed := s.stops[symbol]
+	waiting := make([]*Order, 0, len(armed))
+	triggered := make([]*Order, 0)
+	for _, order := range armed {
+		order.ratchet(price, tick)
+		if order.stopReached(price) {
+			triggered = append(triggered, order)
+		} else {
+			waiting = append(waiting, order)
+		}
+	}
+	s.stops[symbol] = waiting
+
+	for _, order := range triggered {
+		// A triggered exit cancels its siblings first, which also frees the
+		// funds a take-profit sibling was holding for the same position
+		if order.GroupID != "" && order.GroupRole != "entry" {
+			s.cancelSiblings(order)
+		}
+
+		now := time.Now()
+		order.TriggeredAt = &now
+		if err := s.submit(order); err != nil {
+			log.Printf("Stop order %s for %s rejected on trigger: %v", order.ID, order.Username, err)
+			order.Status = "rejected"
+			s.touch(order)
+		}
+	}
+}
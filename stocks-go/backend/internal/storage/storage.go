@@ -3,52 +3,135 @@ package storage
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/history"
+	"stocks-backend/internal/matching"
 	"sync"
 	"time"
 )
 
 // Order represents a trading order
 type Order struct {
-	ID        string    `json:"id"`
-	Username  string    `json:"username"`
-	Symbol    string    `json:"symbol"`
-	Side      string    `json:"side"`      // "buy" or "sell"
-	OrderType string    `json:"orderType"` // "market" or "limit"
-	Quantity  int       `json:"quantity"`
-	Price     float64   `json:"price"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID        string          `json:"id"`
+	Username  string          `json:"username"`
+	Symbol    string          `json:"symbol"`
+	Side      string          `json:"side"`      // "buy" or "sell"
+	OrderType string          `json:"orderType"` // "market", "limit", "stop", "stop_limit" or "trailing_stop"
+	Quantity  int             `json:"quantity"`
+	Price     decimal.Decimal `json:"price"`
+	Status    string          `json:"status"` // "held", "armed", "queued", "pending", "partially_filled", "done", "cancelled", "replaced", "rejected" or "expired"
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt *time.Time      `json:"updatedAt,omitempty"`
+
+	// TimeInForce is "GTC" (default), "DAY", "GTD", "IOC" or "FOK". DAY and
+	// GTD orders expire at ExpiresAt.
+	TimeInForce string     `json:"timeInForce"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+
+	FilledQuantity int             `json:"filledQuantity"`
+	AvgFillPrice   decimal.Decimal `json:"avgFillPrice"`
+
+	// Stop orders wait, "armed", until the market reaches StopPrice
+	StopPrice   decimal.Decimal `json:"stopPrice,omitempty"`
+	TriggeredAt *time.Time      `json:"triggeredAt,omitempty"`
+
+	// Trailing stops recompute StopPrice from the best price seen so far,
+	// offset by either an absolute amount or a percentage
+	TrailAmount    decimal.Decimal `json:"trailAmount,omitempty"`
+	TrailPercent   float64         `json:"trailPercent,omitempty"`
+	TrailReference decimal.Decimal `json:"trailReference,omitempty"`
+
+	// Bracket and OCO orders belong to a group; GroupRole is "entry",
+	// "take_profit" or "stop_loss"
+	GroupID   string `json:"groupId,omitempty"`
+	GroupRole string `json:"groupRole,omitempty"`
+
+	// Liquidation marks orders placed by a forced margin liquidation
+	Liquidation bool `json:"liquidation,omitempty"`
+
+	hold string // what the order reserves while resting; see holdFor
+	seq  uint64 // when the order last joined its book or the armed stops
+
+	// Amendments that lose queue priority replace the order with a new one
+	ReplacesID string `json:"replacesId,omitempty"`
+	ReplacedBy string `json:"replacedBy,omitempty"`
 }
 
 // StockPrice represents the current price of a stock
 type StockPrice struct {
-	Symbol       string    `json:"symbol"`
-	Price        float64   `json:"price"`
-	Change       float64   `json:"change"` // percentage change
-	PriceHistory []float64 `json:"priceHistory"`
-	Logo         string    `json:"logo"`
-	Name         string    `json:"name"`
+	Symbol       string            `json:"symbol"`
+	Price        decimal.Decimal   `json:"price"`
+	Change       float64           `json:"change"` // percentage change
+	PriceHistory []decimal.Decimal `json:"priceHistory"`
+	TickSize     decimal.Decimal   `json:"tickSize"` // prices are multiples of this
+	LotSize      int               `json:"lotSize"`  // quantities are multiples of this
+	Logo         string            `json:"logo"`
+	Name         string            `json:"name"`
 }
 
 // UserAccount represents a user's trading account
 type UserAccount struct {
-	Username     string         `json:"username"`
-	PasswordHash string         `json:"-"` // Don't expose in JSON
-	Credits      float64        `json:"credits"`
-	Portfolio    map[string]int `json:"portfolio"` // symbol -> quantity
-	mutex        sync.RWMutex
+	Username        string          `json:"username"`
+	PasswordHash    string          `json:"-"`                    // Don't expose in JSON
+	AccountType     string          `json:"accountType"`          // "cash" or "margin"
+	Credits         decimal.Decimal `json:"credits"`              // may go negative in margin accounts
+	Portfolio       map[string]int  `json:"portfolio"`            // symbol -> quantity, negative for shorts
+	ReservedCredits decimal.Decimal `json:"reservedCredits"`      // held by resting buy orders
+	ReservedShares  map[string]int  `json:"reservedShares"`       // symbol -> quantity held by resting sell orders
+	MarginCall      *time.Time      `json:"marginCall,omitempty"` // set while a margin call is outstanding
+
+	// Open lots and realized P&L by symbol; see bookFill
+	Lots        map[string][]Lot           `json:"lots,omitempty"`
+	RealizedPnL map[string]decimal.Decimal `json:"realizedPnL,omitempty"`
+
+	mutex sync.RWMutex
 }
 
 // Storage provides thread-safe in-memory storage
 type Storage struct {
-	orders      []Order
-	ordersMutex sync.RWMutex
+	orders      []*Order
+	orderIndex  map[string]*Order
+	books       map[string]*matching.OrderBook
+	stops       map[string][]*Order // symbol -> armed stop orders
+	groups      map[string]*OrderGroup
+	groupQueue  []*Order            // grouped orders changed since groups were last settled
+	queued      map[string][]*Order // symbol -> orders waiting for a halt to end
+	trades      []Trade
+	actions     map[string]*CorporateAction
+	sequence    uint64       // last Order.seq handed out
+	ordersMutex sync.RWMutex // guards orders, books, stops, groups, queued, trades and actions
 
 	prices      map[string]*StockPrice
-	pricesMutex sync.RWMutex
+	instruments map[string]*Instrument // listed symbols' reference data
+	pricesMutex sync.RWMutex           // guards prices and instruments
+	ticks       *history.Store         // timestamped long-horizon price history
+
+	candles      map[string][]candleSeries // symbol -> bars by CandleIntervals index
+	feedTime     map[string]time.Time      // symbol -> time of its latest price tick
+	candlesMutex sync.RWMutex              // guards candles and feedTime
 
 	accounts      map[string]*UserAccount
 	accountsMutex sync.RWMutex
+
+	margin MarginPolicy
+	fees   decimal.Decimal // commission rate; see SetFeeRate
+
+	costBasis string // cost basis method; see SetCostBasisMethod
+
+	breakers   CircuitBreakerPolicy
+	halts      map[string]*Halt // symbol -> halt in force
+	haltEvents []HaltEvent      // halts and resumptions not yet reported by CheckHalts
+	haltsMutex sync.RWMutex
+
+	ledger      []LedgerEntry
+	ledgerMutex sync.RWMutex
+
+	// changedAccounts collects the accounts modified by the operation in
+	// progress when a FileStore is journaling them; nil otherwise
+	changedAccounts map[string]bool
+
+	events      chan<- UserEvent // see NotifyUserEvents
+	eventsMutex sync.RWMutex
 }
 
 var instance *Storage
@@ -57,64 +140,46 @@ var once sync.Once
 // GetInstance returns the singleton storage instance
 func GetInstance() *Storage {
 	once.Do(func() {
-		instance = &Storage{
-			orders:   make([]Order, 0),
-			prices:   make(map[string]*StockPrice),
-			accounts: make(map[string]*UserAccount),
-		}
-		// Initialize mock stock prices with logos
-		instance.prices["AAPL"] = &StockPrice{
-			Symbol:       "AAPL",
-			Price:        150.00,
-			Change:       0.0,
-			PriceHistory: []float64{150.00},
-			Logo:         "https://logo.clearbit.com/apple.com",
-			Name:         "Apple Inc.",
-		}
-		instance.prices["TSLA"] = &StockPrice{
-			Symbol:       "TSLA",
-			Price:        250.00,
-			Change:       0.0,
-			PriceHistory: []float64{250.00},
-			Logo:         "https://logo.clearbit.com/tesla.com",
-			Name:         "Tesla, Inc.",
-		}
-		instance.prices["AMZN"] = &StockPrice{
-			Symbol:       "AMZN",
-			Price:        135.00,
-			Change:       0.0,
-			PriceHistory: []float64{135.00},
-			Logo:         "https://logo.clearbit.com/amazon.com",
-			Name:         "Amazon.com, Inc.",
-		}
-		instance.prices["GOOGL"] = &StockPrice{
-			Symbol:       "GOOGL",
-			Price:        140.00,
-			Change:       0.0,
-			PriceHistory: []float64{140.00},
-			Logo:         "https://logo.clearbit.com/google.com",
-			Name:         "Alphabet Inc.",
-		}
-		instance.prices["MSFT"] = &StockPrice{
-			Symbol:       "MSFT",
-			Price:        380.00,
-			Change:       0.0,
-			PriceHistory: []float64{380.00},
-			Logo:         "https://logo.clearbit.com/microsoft.com",
-			Name:         "Microsoft Corporation",
-		}
+		instance = newStorage()
 	})
 	return instance
 }
 
+// newStorage creates empty in-memory storage; see ListInstrument
+func newStorage() *Storage {
+	return &Storage{
+		orders:      make([]*Order, 0),
+		orderIndex:  make(map[string]*Order),
+		books:       make(map[string]*matching.OrderBook),
+		stops:       make(map[string][]*Order),
+		groups:      make(map[string]*OrderGroup),
+		queued:      make(map[string][]*Order),
+		trades:      make([]Trade, 0),
+		prices:      make(map[string]*StockPrice),
+		instruments: make(map[string]*Instrument),
+		actions:     make(map[string]*CorporateAction),
+		candles:     make(map[string][]candleSeries),
+		feedTime:    make(map[string]time.Time),
+		ticks:       history.NewStore(history.DefaultConfig),
+		accounts:    make(map[string]*UserAccount),
+		margin:      DefaultMarginPolicy,
+		breakers:    DefaultCircuitBreakerPolicy,
+		halts:       make(map[string]*Halt),
+		ledger:      make([]LedgerEntry, 0),
+	}
+}
+
 // hashPassword creates a SHA-256 hash of the password
 func hashPassword(password string) string {
 	hash := sha256.Sum256([]byte(password))
 	return hex.EncodeToString(hash[:])
 }
 
-// CreateAccount creates a new user account with initial credits
-func (s *Storage) CreateAccount(username, password string) *UserAccount {
+// initialCredits is the cash deposited into every new account
+var initialCredits = decimal.FromInt(2000)
+
+// CreateAccount creates a new "cash" or "margin" user account with initial credits
+func (s *Storage) CreateAccount(username, password, accountType string) *UserAccount {
 	s.accountsMutex.Lock()
 	defer s.accountsMutex.Unlock()
 
@@ -122,13 +187,35 @@ func (s *Storage) CreateAccount(username, password string) *UserAccount {
 		return nil // Account already exists
 	}
 
-	s.accounts[username] = &UserAccount{
-		Username:     username,
-		PasswordHash: hashPassword(password),
-		Credits:      2000.0,
-		Portfolio:    make(map[string]int),
+	account := &UserAccount{
+		Username:       username,
+		PasswordHash:   hashPassword(password),
+		AccountType:    accountType,
+		Portfolio:      make(map[string]int),
+		ReservedShares: make(map[string]int),
+		Lots:           make(map[string][]Lot),
+		RealizedPnL:    make(map[string]decimal.Decimal),
 	}
-	return s.accounts[username]
+	s.post(LedgerEntry{
+		Type: "deposit",
+		Memo: "Initial credits",
+		Postings: []Posting{
+			{Account: cashAccount(username), Amount: initialCredits},
+			{Account: houseCapital, Amount: -initialCredits},
+		},
+	}, account)
+	s.accounts[username] = account
+	return account
+}
+
+// accountChanged notes that the operation in progress modified account so
+// a FileStore can journal it, and notifies its user. In-memory storage does
+// not track changes.
+func (s *Storage) accountChanged(account *UserAccount) {
+	if s.changedAccounts != nil {
+		s.changedAccounts[account.Username] = true
+	}
+	s.notify(UserEvent{Username: account.Username})
 }
 
 // ValidatePassword checks if the provided password matches the stored hash
@@ -151,11 +238,17 @@ func (s *Storage) GetAccount(username string) *UserAccount {
 	return s.accounts[username]
 }
 
-// AddOrder adds a new order to storage
+// AddOrder adds a new order to storage without matching it
 func (s *Storage) AddOrder(order Order) {
 	s.ordersMutex.Lock()
 	defer s.ordersMutex.Unlock()
+	s.addOrder(&order)
+}
+
+// addOrder records an order; callers must hold ordersMutex
+func (s *Storage) addOrder(order *Order) {
 	s.orders = append(s.orders, order)
+	s.orderIndex[order.ID] = order
 }
 
 // GetOrders returns all orders for a user
@@ -166,16 +259,24 @@ func (s *Storage) GetOrders(username string) []Order {
 	userOrders := make([]Order, 0)
 	for _, order := range s.orders {
 		if order.Username == username {
-			userOrders = append(userOrders, order)
+			userOrders = append(userOrders, *order)
 		}
 	}
 	return userOrders
 }
 
-// UpdatePrice updates a stock price
-func (s *Storage) UpdatePrice(symbol string, newPrice, change float64) {
+// UpdatePrice updates a stock price observed at the given time: now for
+// simulated prices, or the bar's timestamp when replaying history, which
+// the price history and bars are stamped with. Prices of halted symbols do
+// not change, and a price outside the circuit breaker bands is held at the
+// band it breached; see checkBands. Halts run on the wall clock.
+func (s *Storage) UpdatePrice(symbol string, newPrice decimal.Decimal, change float64, at time.Time) {
+	newPrice, change, ok := s.checkBands(symbol, newPrice, change, at)
+	if !ok {
+		return
+	}
+
 	s.pricesMutex.Lock()
-	defer s.pricesMutex.Unlock()
 	if price, exists := s.prices[symbol]; exists {
 		price.Price = newPrice
 		price.Change = change
@@ -185,86 +286,14 @@ func (s *Storage) UpdatePrice(symbol string, newPrice, change float64) {
 			price.PriceHistory = price.PriceHistory[len(price.PriceHistory)-20:]
 		}
 	}
+	s.pricesMutex.Unlock()
+	s.ticks.Add(symbol, at, newPrice)
+	s.recordTick(symbol, newPrice, at)
 
 	// Check and update order statuses
 	s.updateOrderStatuses(symbol, newPrice)
 }
 
-// ExecuteBuyOrder executes a buy order with proper validation
-func (s *Storage) ExecuteBuyOrder(username, symbol string, quantity int, price float64, orderType string) error {
-	account := s.GetAccount(username)
-	if account == nil {
-		return &OrderError{"Account not found"}
-	}
-
-	// For market orders, use current market price
-	actualPrice := price
-	if orderType == "market" {
-		stockPrice, exists := s.GetPrice(symbol)
-		if !exists {
-			return &OrderError{"Stock not found"}
-		}
-		actualPrice = stockPrice.Price
-	}
-
-	totalCost := float64(quantity) * actualPrice
-
-	account.mutex.Lock()
-	defer account.mutex.Unlock()
-
-	if account.Credits < totalCost {
-		return &OrderError{"Insufficient credits"}
-	}
-
-	// For market orders, execute immediately
-	if orderType == "market" {
-		account.Credits -= totalCost
-		account.Portfolio[symbol] += quantity
-		return nil
-	}
-
-	// For limit orders, just validate credits (execution happens when price condition is met)
-	return nil
-}
-
-// ExecuteSellOrder executes a sell order with proper validation
-func (s *Storage) ExecuteSellOrder(username, symbol string, quantity int, price float64, orderType string) error {
-	account := s.GetAccount(username)
-	if account == nil {
-		return &OrderError{"Account not found"}
-	}
-
-	account.mutex.Lock()
-	defer account.mutex.Unlock()
-
-	// Check if user has enough stocks
-	if account.Portfolio[symbol] < quantity {
-		return &OrderError{"Insufficient stocks to sell"}
-	}
-
-	// For market orders, execute immediately
-	if orderType == "market" {
-		stockPrice, exists := s.GetPrice(symbol)
-		if !exists {
-			return &OrderError{"Stock not found"}
-		}
-
-		totalRevenue := float64(quantity) * stockPrice.Price
-		account.Credits += totalRevenue
-		account.Portfolio[symbol] -= quantity
-
-		// Remove from portfolio if quantity becomes 0
-		if account.Portfolio[symbol] == 0 {
-			delete(account.Portfolio, symbol)
-		}
-		return nil
-	}
-
-	// For limit orders, just remove stocks from available inventory
-	// (they'll be added back if order is cancelled or fails)
-	return nil
-}
-
 // OrderError represents an order validation error
 type OrderError struct {
 	Message string
@@ -274,6 +303,14 @@ func (e *OrderError) Error() string {
 	return e.Message
 }
 
+// Errors returned when looking up or changing an existing order
+var (
+	ErrOrderNotFound = &OrderError{"Order not found"}
+	ErrNotOrderOwner = &OrderError{"Order belongs to another user"}
+	ErrOrderNotOpen  = &OrderError{"Order is no longer open"}
+	ErrNotAmendable  = &OrderError{"Only limit orders resting on the book can be amended; cancel and resubmit stop orders instead"}
+)
+
 // GetPrice returns the price for a specific symbol
 func (s *Storage) GetPrice(symbol string) (*StockPrice, bool) {
 	s.pricesMutex.RLock()
@@ -286,6 +323,8 @@ func (s *Storage) GetPrice(symbol string) (*StockPrice, bool) {
 			Price:        price.Price,
 			Change:       price.Change,
 			PriceHistory: price.PriceHistory,
+			TickSize:     price.TickSize,
+			LotSize:      price.LotSize,
 			Logo:         price.Logo,
 			Name:         price.Name,
 		}
@@ -305,6 +344,8 @@ func (s *Storage) GetAllPrices() []StockPrice {
 			Price:        price.Price,
 			Change:       price.Change,
 			PriceHistory: price.PriceHistory,
+			TickSize:     price.TickSize,
+			LotSize:      price.LotSize,
 			Logo:         price.Logo,
 			Name:         price.Name,
 		})
@@ -312,47 +353,32 @@ func (s *Storage) GetAllPrices() []StockPrice {
 	return prices
 }
 
-// updateOrderStatuses checks and updates order statuses based on current price
-func (s *Storage) updateOrderStatuses(symbol string, currentPrice float64) {
-	s.ordersMutex.Lock()
-	defer s.ordersMutex.Unlock()
+// PriceTicks returns a symbol's timestamped price history in [from, to),
+// oldest first. Recent periods come as raw ticks and older ones as compacted
+// points; a resolution above zero compacts them all to at least that.
+func (s *Storage) PriceTicks(symbol string, from, to time.Time, resolution time.Duration) ([]history.Point, error) {
+	if _, exists := s.GetPrice(symbol); !exists {
+		return nil, ErrStockNotFound
+	}
+	return s.ticks.Range(symbol, from, to, resolution), nil
+}
 
-	for i := range s.orders {
-		order := &s.orders[i]
-
-		// Only process limit orders that are pending
-		if order.Symbol == symbol && order.Status == "pending" && order.OrderType == "limit" {
-			// Buy limit order: execute if current price <= order price
-			if order.Side == "buy" && currentPrice <= order.Price {
-				account := s.GetAccount(order.Username)
-				if account != nil {
-					account.mutex.Lock()
-					totalCost := float64(order.Quantity) * currentPrice
-					if account.Credits >= totalCost {
-						account.Credits -= totalCost
-						account.Portfolio[symbol] += order.Quantity
-						order.Status = "done"
-					}
-					account.mutex.Unlock()
-				}
-			}
-			// Sell limit order: execute if current price >= order price
-			if order.Side == "sell" && currentPrice >= order.Price {
-				account := s.GetAccount(order.Username)
-				if account != nil {
-					account.mutex.Lock()
-					if account.Portfolio[symbol] >= order.Quantity {
-						totalRevenue := float64(order.Quantity) * currentPrice
-						account.Credits += totalRevenue
-						account.Portfolio[symbol] -= order.Quantity
-						if account.Portfolio[symbol] == 0 {
-							delete(account.Portfolio, symbol)
-						}
-						order.Status = "done"
-					}
-					account.mutex.Unlock()
-				}
-			}
+// RewindPrices drops a symbol's price history and bars from the given time
+// on, for a price feed that goes back in time, as a replay does when it
+// starts, loops or seeks. Ticks from that time on are then recorded again
+// rather than ignored as older than the newest; bars in progress at that
+// time are dropped too, and reopened by the next tick.
+func (s *Storage) RewindPrices(symbol string, from time.Time) {
+	s.ticks.Truncate(symbol, from)
+
+	s.candlesMutex.Lock()
+	defer s.candlesMutex.Unlock()
+	all := s.candles[symbol]
+	for i, bars := range all {
+		keep := len(bars)
+		for keep > 0 && bars[keep-1].Start.Add(CandleIntervals[i].Duration).After(from) {
+			keep--
 		}
+		all[i] = bars[:keep]
 	}
 }
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"stocks-backend/internal/decimal"
+	"stocks-backend/internal/history"
+	"stocks-backend/internal/matching"
+	"time"
+)
+
+// Store is the accounts, orders and prices storage used by the API and the
+// simulator. Storage keeps everything in memory; FileStore wraps it and
+// journals every change to disk so the state survives a restart.
+type Store interface {
+	// Accounts
+	CreateAccount(username, password, accountType string) *UserAccount
+	ValidatePassword(username, password string) bool
+	GetAccount(username string) *UserAccount
+	SetMarginPolicy(policy MarginPolicy)
+	Margin(account *UserAccount) MarginStatus
+	CheckMargins() []MarginEvent
+	SetCostBasisMethod(method string)
+	ProfitAndLoss(account *UserAccount) ProfitAndLoss
+
+	// Ledger
+	SetFeeRate(rate decimal.Decimal)
+	GetLedger(username string) []LedgerLine
+	CheckLedger() LedgerReport
+
+	// Events
+	NotifyUserEvents(events chan<- UserEvent)
+
+	// Orders
+	SubmitOrder(order *Order) error
+	SubmitBracket(groupID string, entry, takeProfit, stopLoss *Order) ([]Order, error)
+	SubmitOCO(groupID string, takeProfit, stopLoss *Order) ([]Order, error)
+	CancelOrder(username, orderID string) (Order, error)
+	AmendOrder(username, orderID string, price decimal.Decimal, quantity int) (Order, error)
+	ExpireOrders(now time.Time) []Order
+	SetCircuitBreakerPolicy(policy CircuitBreakerPolicy)
+	CheckHalts(now time.Time) []HaltEvent
+	Halts() []Halt
+	GetOrder(username, orderID string) (Order, error)
+	GetOrders(username string) []Order
+	GetExecutions(username, symbol, orderID string) []Execution
+	OrderBookDepth(symbol string, levels int) (bids, asks []matching.Level, ok bool)
+
+	// Instruments
+	Instruments() []Instrument
+	Instrument(symbol string) (Instrument, bool)
+	ListInstrument(instrument Instrument) (Instrument, error)
+	DelistInstrument(symbol string, price decimal.Decimal) (Delisting, error)
+
+	// Corporate actions
+	CorporateActions(symbol string) []CorporateAction
+	ScheduleCorporateAction(action CorporateAction) (CorporateAction, error)
+	CancelCorporateAction(id string) (CorporateAction, error)
+	ApplyCorporateActions(now time.Time) []CorporateAction
+
+	// Prices
+	UpdatePrice(symbol string, newPrice decimal.Decimal, change float64, at time.Time)
+	GetPrice(symbol string) (*StockPrice, bool)
+	GetAllPrices() []StockPrice
+	PriceTicks(symbol string, from, to time.Time, resolution time.Duration) ([]history.Point, error)
+	Candles(symbol, interval string, from, to time.Time) ([]Candle, error)
+	CurrentCandles(symbol string) []Candle
+	RewindPrices(symbol string, from time.Time)
+}
+
+var (
+	_ Store = (*Storage)(nil)
+	_ Store = (*FileStore)(nil)
+)
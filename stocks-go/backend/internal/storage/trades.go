@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"stocks-backend/internal/decimal"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Trade records a single execution between a buy and a sell order. An empty
+// order ID and username on one side mean the simulated market took it.
+type Trade struct {
+	ID          string          `json:"id"`
+	Symbol      string          `json:"symbol"`
+	Price       decimal.Decimal `json:"price"`
+	Quantity    int             `json:"quantity"`
+	BuyOrderID  string          `json:"buyOrderId,omitempty"`
+	SellOrderID string          `json:"sellOrderId,omitempty"`
+	Buyer       string          `json:"-"`
+	Seller      string          `json:"-"`
+	Aggressor   string          `json:"aggressor"` // side that took liquidity: "buy" or "sell"
+	ExecutedAt  time.Time       `json:"executedAt"`
+}
+
+// Execution is one user's side of a trade
+type Execution struct {
+	TradeID    string          `json:"tradeId"`
+	OrderID    string          `json:"orderId"`
+	Symbol     string          `json:"symbol"`
+	Side       string          `json:"side"`
+	Price      decimal.Decimal `json:"price"`
+	Quantity   int             `json:"quantity"`
+	Liquidity  string          `json:"liquidity"` // "maker" if the order was resting, otherwise "taker"
+	ExecutedAt time.Time       `json:"executedAt"`
+}
+
+// Remaining returns the quantity of the order that has not executed yet
+func (o *Order) Remaining() int {
+	return o.Quantity - o.FilledQuantity
+}
+
+// applyFill records an execution against the order and updates its status
+func (o *Order) applyFill(quantity int, price decimal.Decimal) {
+	filled := o.FilledQuantity + quantity
+	o.AvgFillPrice = (o.AvgFillPrice.MulInt(o.FilledQuantity) + price.MulInt(quantity)).DivInt(filled)
+	o.FilledQuantity = filled
+
+	o.Status = "partially_filled"
+	if o.Remaining() == 0 {
+		o.Status = "done"
+	}
+}
+
+// execute settles a trade between buy and sell, either of which may be nil
+// for the simulated market, records it and updates both orders. It returns
+// the quantity executed. Callers must hold ordersMutex.
+func (s *Storage) execute(buy, sell *Order, quantity int, price decimal.Decimal, aggressor string) int {
+	trade := Trade{
+		ID:         uuid.New().String(),
+		Price:      price,
+		Aggressor:  aggressor,
+		ExecutedAt: time.Now(),
+	}
+	if buy != nil {
+		trade.Symbol, trade.BuyOrderID, trade.Buyer = buy.Symbol, buy.ID, buy.Username
+	}
+	if sell != nil {
+		trade.Symbol, trade.SellOrderID, trade.Seller = sell.Symbol, sell.ID, sell.Username
+	}
+
+	trade.Quantity = s.settle(trade.ID, trade.Buyer, trade.Seller, trade.Symbol, quantity, price)
+	if trade.Quantity == 0 {
+		return 0
+	}
+
+	if buy != nil {
+		buy.applyFill(trade.Quantity, price)
+		s.touch(buy)
+	}
+	if sell != nil {
+		sell.applyFill(trade.Quantity, price)
+		s.touch(sell)
+	}
+	s.trades = append(s.trades, trade)
+	s.recordVolume(trade)
+	return trade.Quantity
+}
+
+// GetExecutions returns the user's executions, oldest first, optionally
+// limited to a symbol and/or order
+func (s *Storage) GetExecutions(username, symbol, orderID string) []Execution {
+	s.ordersMutex.RLock()
+	defer s.ordersMutex.RUnlock()
+
+	executions := make([]Execution, 0)
+	for _, trade := range s.trades {
+		if symbol != "" && trade.Symbol != symbol {
+			continue
+		}
+		if trade.Buyer == username && (orderID == "" || trade.BuyOrderID == orderID) {
+			executions = append(executions, trade.execution(trade.BuyOrderID, "buy"))
+		}
+		if trade.Seller == username && (orderID == "" || trade.SellOrderID == orderID) {
+			executions = append(executions, trade.execution(trade.SellOrderID, "sell"))
+		}
+	}
+	return executions
+}
+
+func (t Trade) execution(orderID, side string) Execution {
+	liquidity := "maker"
+	if side == t.Aggressor {
+		liquidity = "taker"
+	}
+	return Execution{
+		TradeID:    t.ID,
+		OrderID:    orderID,
+		Symbol:     t.Symbol,
+		Side:       side,
+		Price:      t.Price,
+		Quantity:   t.Quantity,
+		Liquidity:  liquidity,
+		ExecutedAt: t.ExecutedAt,
+	}
+}
@@ -4,118 +4,307 @@ import (
 	"encoding/json"
 	"log"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+// maxControlSize is the largest control message a client may send
+const maxControlSize = 4096
+
+// releaseInterval is how often updates held back from slow clients are
+// retried when nothing else is published
+const releaseInterval = 100 * time.Millisecond
+
 // Client represents a WebSocket client connection
 type Client struct {
-	Hub  *Hub
-	Conn *websocket.Conn
-	Send chan []byte
+	ID          string
+	Hub         *Hub
+	Conn        *websocket.Conn
+	Send        chan []byte
+	ConnectedAt time.Time
+
+	// Username is the authenticated user, if any. It may be set before the
+	// client registers; after that only the hub's Run loop changes it.
+	Username string
+
+	// Only touched by the hub's Run loop, under its mutex
+	topics    map[Topic]bool   // subscriptions
+	held      map[Topic][]byte // latest updates held back by the Conflate policy
+	dropped   uint64
+	conflated uint64
+
+	options Options // keepalive timeouts, fixed when the client is created
+}
+
+// NewClient creates a client for a WebSocket connection, authenticated as
+// username unless it is empty, with the hub's buffer size and timeouts
+func NewClient(hub *Hub, conn *websocket.Conn, username string) *Client {
+	hub.mutex.RLock()
+	options := hub.options
+	hub.mutex.RUnlock()
+
+	return &Client{
+		ID:          uuid.New().String(),
+		Hub:         hub,
+		Conn:        conn,
+		Send:        make(chan []byte, options.SendBuffer),
+		ConnectedAt: time.Now(),
+		Username:    username,
+		options:     options,
+	}
+}
+
+// Topic is what a message is published on: a channel and, for symbol
+// channels, the symbol it is about. Subscribers to Symbol "*" receive the
+// channel's messages for every symbol. The private account channel is keyed
+// by username in Symbol instead.
+type Topic struct {
+	Channel string
+	Symbol  string
+}
+
+// message is a message published on a topic
+type message struct {
+	topic Topic
+	data  []byte
+}
+
+// request is a control message read from a client
+type request struct {
+	client *Client
+	data   []byte
 }
 
-// Hub maintains the set of active clients and broadcasts messages
+// Hub maintains the set of active clients and their subscriptions, and
+// routes each published message to the clients subscribed to its topic
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	Register   chan *Client
-	Unregister chan *Client
-	mutex      sync.RWMutex
+	clients     map[*Client]bool
+	subscribers map[Topic]map[*Client]bool
+	backlogged  map[*Client]bool // clients with updates held back
+	publish     chan message
+	requests    chan request
+	Register    chan *Client
+	Unregister  chan *Client
+	mutex       sync.RWMutex
+
+	options      Options
+	disconnected uint64 // slow clients disconnected
 }
 
-// NewHub creates a new Hub instance
+// NewHub creates a new Hub instance with DefaultOptions
 func NewHub() *Hub {
 	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte, 256),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
+		clients:     make(map[*Client]bool),
+		subscribers: make(map[Topic]map[*Client]bool),
+		backlogged:  make(map[*Client]bool),
+		publish:     make(chan message, 256),
+		requests:    make(chan request, 16),
+		Register:    make(chan *Client),
+		Unregister:  make(chan *Client),
+		options:     DefaultOptions,
 	}
 }
 
-// Run starts the hub's main loop
+// Run starts the hub's main loop. Clients, subscriptions and the Send
+// channels are only changed here, so a client's control messages are
+// handled in order after it registers, and a Send channel is closed once,
+// by whichever of unregistering or the slow consumer policy comes first.
 func (h *Hub) Run() {
+	ticker := time.NewTicker(releaseInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case client := <-h.Register:
 			h.mutex.Lock()
 			h.clients[client] = true
+			client.topics = make(map[Topic]bool)
+			client.held = make(map[Topic][]byte)
+			if client.Username != "" {
+				h.subscribe(client, accountTopic(client.Username))
+			}
 			h.mutex.Unlock()
 			log.Printf("Client connected. Total clients: %d", len(h.clients))
 
 		case client := <-h.Unregister:
 			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.Send)
-			}
+			h.remove(client)
 			h.mutex.Unlock()
 			log.Printf("Client disconnected. Total clients: %d", len(h.clients))
 
-		case message := <-h.broadcast:
-			h.mutex.RLock()
-			for client := range h.clients {
-				select {
-				case client.Send <- message:
-				default:
-					// If we can't send, close the client
-					close(client.Send)
-					delete(h.clients, client)
-				}
+		case req := <-h.requests:
+			h.mutex.Lock()
+			if h.clients[req.client] {
+				h.control(req.client, req.data)
+			}
+			h.mutex.Unlock()
+
+		case msg := <-h.publish:
+			h.mutex.Lock()
+			for _, client := range h.recipients(msg.topic) {
+				h.send(client, msg)
 			}
-			h.mutex.RUnlock()
+			h.mutex.Unlock()
+
+		case <-ticker.C:
+			h.mutex.Lock()
+			h.releaseAll()
+			h.mutex.Unlock()
 		}
 	}
 }
 
-// Broadcast sends a message to all connected clients
-func (h *Hub) Broadcast(data interface{}) error {
-	message, err := json.Marshal(data)
+// recipients returns the clients subscribed to topic, directly or through
+// a subscription to all of the channel's symbols. Callers must hold mutex.
+func (h *Hub) recipients(topic Topic) []*Client {
+	clients := make([]*Client, 0, len(h.subscribers[topic]))
+	for client := range h.subscribers[topic] {
+		clients = append(clients, client)
+	}
+	if topic.Symbol != "" && topic.Channel != ChannelAccount {
+		for client := range h.subscribers[Topic{Channel: topic.Channel, Symbol: "*"}] {
+			if !h.subscribers[topic][client] {
+				clients = append(clients, client)
+			}
+		}
+	}
+	return clients
+}
+
+// remove drops a client and its subscriptions and closes its Send channel,
+// which ends its WritePump. Removing a client twice does nothing. Callers
+// must hold mutex.
+func (h *Hub) remove(client *Client) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	for topic := range client.topics {
+		h.unsubscribe(client, topic)
+	}
+	clear(client.held)
+	delete(h.backlogged, client)
+	delete(h.clients, client)
+	close(client.Send)
+}
+
+// subscribe adds a client to a topic's subscribers. Callers must hold mutex.
+func (h *Hub) subscribe(client *Client, topic Topic) {
+	if h.subscribers[topic] == nil {
+		h.subscribers[topic] = make(map[*Client]bool)
+	}
+	h.subscribers[topic][client] = true
+	client.topics[topic] = true
+}
+
+// unsubscribe removes a client from a topic's subscribers. Callers must
+// hold mutex.
+func (h *Hub) unsubscribe(client *Client, topic Topic) {
+	delete(client.topics, topic)
+	if subscribers := h.subscribers[topic]; subscribers != nil {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(h.subscribers, topic)
+		}
+	}
+}
+
+// Publish sends a message to the clients subscribed to channel and, for
+// symbol channels, symbol. Market-wide channels take an empty symbol.
+func (h *Hub) Publish(channel, symbol string, data interface{}) error {
+	encoded, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
-	h.broadcast <- message
+	if !symbolChannels[channel] {
+		symbol = ""
+	}
+	h.publish <- message{topic: Topic{Channel: channel, Symbol: symbol}, data: encoded}
 	return nil
 }
 
-// ReadPump reads messages from the WebSocket connection
+// PublishUser sends a message to the clients authenticated as username on
+// the private account channel
+func (h *Hub) PublishUser(username string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	h.publish <- message{topic: accountTopic(username), data: encoded}
+	return nil
+}
+
+// Watching reports whether any client is subscribed to username's account
+// channel, so that events nobody would receive need not be built
+func (h *Hub) Watching(username string) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.subscribers[accountTopic(username)]) > 0
+}
+
+// accountTopic is the private topic of username's account events
+func accountTopic(username string) Topic {
+	return Topic{Channel: ChannelAccount, Symbol: username}
+}
+
+// ReadPump reads control messages from the WebSocket connection and hands
+// them to the hub. Any message or pong extends the read deadline; a client
+// silent for longer than PongWait is disconnected.
 func (c *Client) ReadPump() {
 	defer func() {
 		c.Hub.Unregister <- c
 		c.Conn.Close()
 	}()
 
+	c.Conn.SetReadLimit(maxControlSize)
+	c.Conn.SetReadDeadline(time.Now().Add(c.options.PongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(time.Now().Add(c.options.PongWait))
+	})
 	for {
-		_, _, err := c.Conn.ReadMessage()
+		_, data, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
 		}
-		// We don't expect messages from clients in this implementation
-		// If needed, handle incoming messages here
+		c.Conn.SetReadDeadline(time.Now().Add(c.options.PongWait))
+		c.Hub.requests <- request{client: c, data: data}
 	}
 }
 
-// WritePump writes messages to the WebSocket connection
+// WritePump writes messages to the WebSocket connection and pings the
+// client every PingInterval. A write blocked for longer than WriteWait
+// closes the connection, which ends ReadPump and unregisters the client.
 func (c *Client) WritePump() {
+	ticker := time.NewTicker(c.options.PingInterval)
 	defer func() {
+		ticker.Stop()
 		c.Conn.Close()
 	}()
 
 	for {
-		message, ok := <-c.Send
-		if !ok {
-			// The hub closed the channel
-			c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-			return
-		}
+		select {
+		case message, ok := <-c.Send:
+			c.Conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+			if !ok {
+				// The hub closed the channel
+				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
 
-		err := c.Conn.WriteMessage(websocket.TextMessage, message)
-		if err != nil {
-			return
+			err := c.Conn.WriteMessage(websocket.TextMessage, message)
+			if err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// SlowConsumerPolicy decides what the hub does with a message for a client
+// whose send buffer is full
+type SlowConsumerPolicy string
+
+const (
+	// Disconnect closes the client's connection
+	Disconnect SlowConsumerPolicy = "disconnect"
+	// DropOldest discards the oldest message waiting in the buffer
+	DropOldest SlowConsumerPolicy = "drop_oldest"
+	// Conflate holds back price and candle updates, keeping only the latest
+	// per symbol until there is room, and drops the oldest of other messages
+	Conflate SlowConsumerPolicy = "conflate"
+)
+
+// conflatable are the channels whose messages supersede the previous one
+// for the same symbol
+var conflatable = map[string]bool{
+	ChannelPrices:  true,
+	ChannelCandles: true,
+}
+
+// ParseSlowConsumerPolicy reads a policy name
+func ParseSlowConsumerPolicy(value string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(value); policy {
+	case Disconnect, DropOldest, Conflate:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid slow consumer policy %q: use disconnect, drop_oldest or conflate", value)
+}
+
+// Options control how the hub keeps connections alive and treats clients
+// that fall behind
+type Options struct {
+	SendBuffer   int                // messages queued per client
+	SlowConsumer SlowConsumerPolicy // what to do when a client's queue is full
+	PingInterval time.Duration      // how often clients are pinged
+	PongWait     time.Duration      // how long a client may go without a pong or message before it is dropped
+	WriteWait    time.Duration      // how long a write may block before the client is dropped
+}
+
+// DefaultOptions pings clients every 54 seconds, drops those silent for a
+// minute or blocking a write for 10 seconds, and disconnects clients that
+// fall 256 messages behind
+var DefaultOptions = Options{
+	SendBuffer:   256,
+	SlowConsumer: Disconnect,
+	PingInterval: 54 * time.Second,
+	PongWait:     60 * time.Second,
+	WriteWait:    10 * time.Second,
+}
+
+// SetOptions replaces the hub's options. The buffer size and timeouts apply
+// to clients created afterwards; the policy applies straight away.
+func (h *Hub) SetOptions(options Options) {
+	if options.SendBuffer <= 0 {
+		options.SendBuffer = DefaultOptions.SendBuffer
+	}
+	if options.SlowConsumer == "" {
+		options.SlowConsumer = DefaultOptions.SlowConsumer
+	}
+	if options.WriteWait <= 0 {
+		options.WriteWait = DefaultOptions.WriteWait
+	}
+	if options.PingInterval <= 0 {
+		options.PingInterval = DefaultOptions.PingInterval
+	}
+	// A pong must have time to arrive before the read deadline passes
+	if options.PongWait <= options.PingInterval {
+		options.PongWait = options.PingInterval * 10 / 9
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.options = options
+}
+
+// ClientStats reports one client's connection and what it has missed
+type ClientStats struct {
+	ID            string    `json:"id"`
+	Username      string    `json:"username,omitempty"`
+	RemoteAddr    string    `json:"remoteAddr"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+	Subscriptions int       `json:"subscriptions"`
+	Queued        int       `json:"queued"`    // messages waiting to be written, including held back updates
+	Dropped       uint64    `json:"dropped"`   // messages discarded because the client fell behind
+	Conflated     uint64    `json:"conflated"` // updates replaced by a later one for the same symbol
+}
+
+// HubStats reports the connected clients and the slow consumer policy
+type HubStats struct {
+	Policy       SlowConsumerPolicy `json:"policy"`
+	Clients      []ClientStats      `json:"clients"`
+	Disconnected uint64             `json:"disconnected"` // slow clients disconnected since startup
+}
+
+// Stats reports the connected clients, oldest first, with their drop counters
+func (h *Hub) Stats() HubStats {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	stats := HubStats{
+		Policy:       h.options.SlowConsumer,
+		Clients:      make([]ClientStats, 0, len(h.clients)),
+		Disconnected: h.disconnected,
+	}
+	for client := range h.clients {
+		stats.Clients = append(stats.Clients, ClientStats{
+			ID:            client.ID,
+			Username:      client.Username,
+			RemoteAddr:    client.Conn.RemoteAddr().String(),
+			ConnectedAt:   client.ConnectedAt,
+			Subscriptions: len(client.topics),
+			Queued:        len(client.Send) + len(client.held),
+			Dropped:       client.dropped,
+			Conflated:     client.conflated,
+		})
+	}
+	sort.Slice(stats.Clients, func(i, j int) bool { return stats.Clients[i].ConnectedAt.Before(stats.Clients[j].ConnectedAt) })
+	return stats
+}
+
+// send queues a message for a client, applying the slow consumer policy if
+// its buffer is full. Callers must hold mutex.
+func (h *Hub) send(client *Client, msg message) {
+	h.release(client)
+	if len(client.held) == 0 {
+		select {
+		case client.Send <- msg.data:
+			return
+		default:
+		}
+	}
+
+	// The buffer is full
+	switch policy := h.options.SlowConsumer; {
+	case policy == Conflate && conflatable[msg.topic.Channel]:
+		if _, superseded := client.held[msg.topic]; superseded {
+			client.conflated++
+		}
+		client.held[msg.topic] = msg.data
+		h.backlogged[client] = true
+
+	case policy == DropOldest || policy == Conflate:
+		// Only the hub sends, so taking one message out makes room
+		select {
+		case <-client.Send:
+			client.dropped++
+		default:
+		}
+		select {
+		case client.Send <- msg.data:
+		default:
+			client.dropped++
+		}
+
+	default:
+		client.dropped++
+		h.disconnected++
+		log.Printf("Disconnecting slow WebSocket client %s after %d messages", client.ID, len(client.Send))
+		h.remove(client)
+	}
+}
+
+// release moves a client's held back updates into its buffer as far as
+// there is room. Callers must hold mutex.
+func (h *Hub) release(client *Client) {
+	for topic, data := range client.held {
+		select {
+		case client.Send <- data:
+			delete(client.held, topic)
+		default:
+			return
+		}
+	}
+	delete(h.backlogged, client)
+}
+
+// releaseAll releases the held back updates of every backlogged client, so
+// they are delivered even when nothing else is published. Callers must
+// hold mutex.
+func (h *Hub) releaseAll() {
+	for client := range h.backlogged {
+		h.release(client)
+	}
+}
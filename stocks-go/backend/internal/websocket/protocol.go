@@ -0,0 +1,206 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"stocks-backend/internal/auth"
+	"strings"
+)
+
+// Channels clients can subscribe to
+const (
+	ChannelPrices           = "prices"           // priceUpdate, per symbol
+	ChannelCandles          = "candles"          // candleUpdate, per symbol
+	ChannelHalts            = "halts"            // halt and resume, per symbol
+	ChannelInstruments      = "instruments"      // instrumentListed and instrumentDelisted, per symbol
+	ChannelCorporateActions = "corporateActions" // corporateAction, per symbol
+	ChannelMarket           = "market"           // marketStatus
+	ChannelAccount          = "account"          // the authenticated user's order, account and margin events
+)
+
+// Channels lists the channels clients can subscribe to
+var Channels = []string{
+	ChannelPrices, ChannelCandles, ChannelHalts, ChannelInstruments,
+	ChannelCorporateActions, ChannelMarket, ChannelAccount,
+}
+
+// symbolChannels maps each channel to whether its messages are about one
+// symbol, which clients subscribe to per symbol
+var symbolChannels = map[string]bool{
+	ChannelPrices:           true,
+	ChannelCandles:          true,
+	ChannelHalts:            true,
+	ChannelInstruments:      true,
+	ChannelCorporateActions: true,
+	ChannelMarket:           false,
+	ChannelAccount:          false,
+}
+
+// Control is a message from a client managing its subscriptions:
+//
+//	{"action": "subscribe", "channels": ["prices", "candles"], "symbols": ["AAPL", "MSFT"]}
+//	{"action": "unsubscribe", "channels": ["candles"], "symbols": ["MSFT"]}
+//	{"action": "list"}
+//	{"action": "auth", "token": "<JWT>"}
+//
+// Subscribing to a symbol channel without symbols, or with "*", subscribes
+// to all of its symbols, including ones listed later. Unsubscribing without
+// symbols drops the channel altogether. Symbols are ignored for market-wide
+// channels. Authenticating subscribes the client to its user's private
+// account channel, which only authenticated clients can subscribe to.
+type Control struct {
+	ID       string   `json:"id,omitempty"` // echoed in the reply
+	Action   string   `json:"action"`       // "subscribe", "unsubscribe", "list" or "auth"
+	Channels []string `json:"channels,omitempty"`
+	Symbols  []string `json:"symbols,omitempty"`
+	Token    string   `json:"token,omitempty"` // for auth
+}
+
+// Subscriptions is the reply to every control message
+type Subscriptions struct {
+	Type          string              `json:"type"` // "subscriptions"
+	ID            string              `json:"id,omitempty"`
+	Subscriptions map[string][]string `json:"subscriptions"`      // symbols subscribed to by channel, empty for market-wide channels
+	Channels      []string            `json:"channels,omitempty"` // channels available, in reply to list
+	Username      string              `json:"username,omitempty"` // the authenticated user, if any
+}
+
+// ControlError is the reply to a control message that could not be applied
+type ControlError struct {
+	Type  string `json:"type"` // "error"
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// control applies a client's control message and replies with its
+// subscriptions or an error. Callers must hold mutex.
+func (h *Hub) control(client *Client, data []byte) {
+	var ctl Control
+	if err := json.Unmarshal(data, &ctl); err != nil {
+		h.reply(client, ControlError{Type: "error", Error: "Invalid control message"})
+		return
+	}
+
+	topics, err := ctl.topics()
+	if err != nil {
+		h.reply(client, ControlError{Type: "error", ID: ctl.ID, Error: err.Error()})
+		return
+	}
+	reply := Subscriptions{Type: "subscriptions", ID: ctl.ID}
+	switch ctl.Action {
+	case "subscribe":
+		for i, topic := range topics {
+			if topic.Channel != ChannelAccount {
+				continue
+			}
+			if client.Username == "" {
+				h.reply(client, ControlError{Type: "error", ID: ctl.ID, Error: "The account channel needs an authenticated connection"})
+				return
+			}
+			topics[i] = accountTopic(client.Username)
+		}
+		for _, topic := range topics {
+			h.subscribe(client, topic)
+		}
+	case "unsubscribe":
+		for _, topic := range topics {
+			if topic.Symbol == "" {
+				for subscribed := range client.topics {
+					if subscribed.Channel == topic.Channel {
+						h.unsubscribe(client, subscribed)
+					}
+				}
+				continue
+			}
+			h.unsubscribe(client, topic)
+		}
+	case "list":
+		reply.Channels = Channels
+	case "auth":
+		claims, err := auth.ValidateToken(ctl.Token)
+		if err != nil {
+			h.reply(client, ControlError{Type: "error", ID: ctl.ID, Error: "Invalid or expired token"})
+			return
+		}
+		if client.Username != claims.Username {
+			h.unsubscribe(client, accountTopic(client.Username))
+			client.Username = claims.Username
+			h.subscribe(client, accountTopic(client.Username))
+		}
+	}
+	reply.Subscriptions = client.subscriptions()
+	reply.Username = client.Username
+	h.reply(client, reply)
+}
+
+// topics checks a control message and returns the topics it names. For
+// unsubscribe, a topic with no symbol stands for the whole channel.
+func (c *Control) topics() ([]Topic, error) {
+	switch c.Action {
+	case "subscribe", "unsubscribe":
+	case "list", "auth":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("action must be 'subscribe', 'unsubscribe', 'list' or 'auth'")
+	}
+	if len(c.Channels) == 0 {
+		return nil, fmt.Errorf("%s needs at least one channel", c.Action)
+	}
+
+	symbols := make([]string, 0, len(c.Symbols))
+	for _, symbol := range c.Symbols {
+		if symbol = strings.ToUpper(strings.TrimSpace(symbol)); symbol != "" {
+			symbols = append(symbols, symbol)
+		}
+	}
+	if len(symbols) == 0 && c.Action == "subscribe" {
+		symbols = []string{"*"}
+	}
+
+	topics := make([]Topic, 0)
+	for _, channel := range c.Channels {
+		perSymbol, known := symbolChannels[channel]
+		if !known {
+			return nil, fmt.Errorf("unknown channel %q", channel)
+		}
+		if !perSymbol || len(symbols) == 0 {
+			topics = append(topics, Topic{Channel: channel})
+			continue
+		}
+		for _, symbol := range symbols {
+			topics = append(topics, Topic{Channel: channel, Symbol: symbol})
+		}
+	}
+	return topics, nil
+}
+
+// subscriptions returns the symbols a client is subscribed to by channel
+func (c *Client) subscriptions() map[string][]string {
+	subscriptions := make(map[string][]string)
+	for topic := range c.topics {
+		symbols := subscriptions[topic.Channel]
+		if symbols == nil {
+			symbols = make([]string, 0)
+		}
+		if symbolChannels[topic.Channel] {
+			symbols = append(symbols, topic.Symbol)
+		}
+		subscriptions[topic.Channel] = symbols
+	}
+	for _, symbols := range subscriptions {
+		sort.Strings(symbols)
+	}
+	return subscriptions
+}
+
+// reply sends a control reply to one client. Callers must hold mutex.
+func (h *Hub) reply(client *Client, data interface{}) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding WebSocket reply: %v", err)
+		return
+	}
+	h.send(client, message{data: encoded})
+}
@@ -26,26 +26,23 @@ const LivePricesTable: React.FC<LivePricesTableProps> = ({ onStockClick }) => {
 
         ws.current.onopen = () => {
             console.log('WebSocket connected');
+            ws.current?.send(JSON.stringify({ action: 'subscribe', channels: ['prices'] }));
         };
 
         ws.current.onmessage = (event) => {
             const data: PriceUpdate = JSON.parse(event.data);
             if (data.type === 'priceUpdate' && data.prices) {
-                // Save initial order if not set
-                if (stockOrderRef.current.length === 0) {
-                    stockOrderRef.current = data.prices.map(p => p.symbol);
-                }
-
-                // Maintain the original order
-                const orderedPrices = stockOrderRef.current
-                    .map(symbol => data.prices.find(p => p.symbol === symbol))
-                    .filter(Boolean) as StockPrice[];
-
+                // Updates arrive one symbol at a time; keep the order they first came in
                 data.prices.forEach((price) => {
+                    if (!stockOrderRef.current.includes(price.symbol)) {
+                        stockOrderRef.current = [...stockOrderRef.current, price.symbol];
+                    }
                     previousPrices.current[price.symbol] = price.price;
                 });
 
-                setPrices(orderedPrices);
+                setPrices(current => stockOrderRef.current
+                    .map(symbol => data.prices.find(p => p.symbol === symbol) ?? current.find(p => p.symbol === symbol))
+                    .filter(Boolean) as StockPrice[]);
             }
         };
 
@@ -3,17 +3,142 @@ export interface StockPrice {
     price: number;
     change: number;
     priceHistory: number[];
+    tickSize: number;
+    lotSize: number;
     logo: string;
     name: string;
 }
 
+export interface Instrument {
+    symbol: string;
+    name: string;
+    logo?: string;
+    sector?: string;
+    currency: string;
+    tickSize: number;
+    lotSize: number;
+    initialPrice: number;
+    model?: Record<string, unknown>;
+    listedAt: string;
+}
+
+export interface CorporateAction {
+    id: string;
+    symbol: string;
+    type: 'split' | 'dividend';
+    to?: number;
+    from?: number;
+    amount?: number;
+    effectiveAt: string;
+    status: 'scheduled' | 'applied' | 'cancelled';
+    scheduledAt: string;
+    appliedAt?: string;
+    accounts: number;
+}
+
 export interface Order {
     id: string;
     symbol: string;
     side: 'buy' | 'sell';
-    orderType: 'market' | 'limit';
+    orderType: 'market' | 'limit' | 'stop' | 'stop_limit' | 'trailing_stop';
     quantity: number;
     price: number;
-    status: 'pending' | 'done';
+    status: 'held' | 'armed' | 'queued' | 'pending' | 'partially_filled' | 'done' | 'cancelled' | 'replaced' | 'rejected' | 'expired';
     createdAt: string;
+    updatedAt?: string;
+    timeInForce: 'GTC' | 'DAY' | 'GTD' | 'IOC' | 'FOK';
+    expiresAt?: string;
+    filledQuantity: number;
+    avgFillPrice: number;
+    replacesId?: string;
+    replacedBy?: string;
+    stopPrice?: number;
+    triggeredAt?: string;
+    trailAmount?: number;
+    trailPercent?: number;
+    trailReference?: number;
+    groupId?: string;
+    groupRole?: 'entry' | 'take_profit' | 'stop_loss';
+    liquidation?: boolean;
+}
+
+export interface MarginStatus {
+    equity: number;
+    longValue: number;
+    shortValue: number;
+    initialRequirement: number;
+    maintenanceRequirement: number;
+    buyingPower: number;
+    availableBuyingPower: number;
+    marginUsage: number;
+    marginCall: boolean;
+}
+
+export interface Execution {
+    tradeId: string;
+    orderId: string;
+    symbol: string;
+    side: 'buy' | 'sell';
+    price: number;
+    quantity: number;
+    liquidity: 'maker' | 'taker';
+    executedAt: string;
+}
+
+export interface LedgerLine {
+    entryId: string;
+    type: 'deposit' | 'trade' | 'fee' | 'adjustment' | 'delisting' | 'split' | 'dividend';
+    reference?: string;
+    memo: string;
+    amount: number;
+    balance: number;
+    postedAt: string;
+}
+
+export interface Lot {
+    quantity: number;
+    cost: number;
+    openedAt: string;
+}
+
+export interface Position {
+    symbol: string;
+    quantity: number;
+    averageCost: number;
+    costBasis: number;
+    marketPrice: number;
+    marketValue: number;
+    unrealizedPnL: number;
+    realizedPnL: number;
+    lots: Lot[];
+}
+
+export interface Candle {
+    symbol: string;
+    interval: '1m' | '5m' | '1h' | '1d';
+    start: string;
+    open: number;
+    high: number;
+    low: number;
+    close: number;
+    volume: number;
+    trades: number;
+}
+
+export interface PricePoint {
+    time: string;
+    price: number;
+    high: number;
+    low: number;
+    count: number;
+}
+
+export interface Halt {
+    symbol: string;
+    reference: number;
+    lower: number;
+    upper: number;
+    price: number;
+    haltedAt: string;
+    resumesAt: string;
 }